@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mmcdole/gofeed"
+)
+
+// Formats are the ways items are rendered. The empty format posts the
+// rendered template as plain markdown.
+var Formats = []string{"plain", "card"}
+
+// DefaultCardColor is the color of cards of feeds without one.
+const DefaultCardColor = "#1C58D9"
+
+var colorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// itemImage returns the image of an item from media:thumbnail, media:content,
+// an image enclosure or the item image, in that order.
+func itemImage(item *gofeed.Item) string {
+	if media, ok := item.Extensions["media"]; ok {
+		candidates := slices.Concat(media["thumbnail"], media["content"])
+		for _, group := range media["group"] {
+			candidates = append(candidates, group.Children["thumbnail"]...)
+			candidates = append(candidates, group.Children["content"]...)
+		}
+		for _, candidate := range candidates {
+			url := candidate.Attrs["url"]
+			if url == "" {
+				continue
+			}
+			if candidate.Name == "thumbnail" || candidate.Attrs["medium"] == "image" || strings.HasPrefix(candidate.Attrs["type"], "image/") {
+				return url
+			}
+		}
+	}
+	for _, enclosure := range item.Enclosures {
+		if strings.HasPrefix(enclosure.Type, "image/") && enclosure.URL != "" {
+			return enclosure.URL
+		}
+	}
+	if item.Image != nil {
+		return item.Image.URL
+	}
+	return ""
+}
+
+// fetchOGImage returns the og:image of the page at link, or an empty string.
+// Only the start of the page is read.
+func fetchOGImage(ctx context.Context, config *configuration, client *http.Client, limiter *hostLimiter, link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return ""
+	}
+	err = limiter.acquire(ctx, u.Host)
+	if err != nil {
+		return ""
+	}
+	defer limiter.release(u.Host)
+	result, err := httpGet(ctx, client, config, link, "", "", MaxPageSize)
+	if err != nil {
+		return ""
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(result.Body))
+	if err != nil {
+		return ""
+	}
+	image, _ := doc.Find(`meta[property="og:image"]`).First().Attr("content")
+	return strings.TrimSpace(image)
+}
+
+// newAttachment renders an item as a card. pageImage is the og:image of the
+// page of the item, shown when the item has no image of its own.
+func (p *Plugin) newAttachment(feed *Feed, page *gofeed.Feed, item *gofeed.Item, pageImage string) *model.SlackAttachment {
+	data := newTemplateData(feed.URL, page, item)
+	color := feed.Color
+	if color == "" {
+		color = DefaultCardColor
+	}
+	feedIcon := ""
+	if page.Image != nil {
+		feedIcon = page.Image.URL
+	}
+	footer := page.Title
+	if footer == "" {
+		footer = feed.URL
+	}
+	attachment := &model.SlackAttachment{
+		Fallback:   data.Item.Title + "\n" + data.Item.Link,
+		Color:      color,
+		AuthorName: data.Item.Author,
+		Title:      data.Item.Title,
+		TitleLink:  data.Item.Link,
+		Text:       data.Item.Summary,
+		ThumbURL:   data.Item.Image,
+		Footer:     footer,
+		FooterIcon: feedIcon,
+	}
+	if attachment.AuthorName != "" {
+		attachment.AuthorIcon = feedIcon
+	}
+	if attachment.ThumbURL == "" {
+		attachment.ThumbURL = pageImage
+	}
+	if len(data.Item.Categories) > 0 {
+		attachment.Fields = append(attachment.Fields, &model.SlackAttachmentField{
+			Title: "Categories",
+			Value: escapeMarkdown(strings.Join(data.Item.Categories, ", ")),
+			Short: false,
+		})
+	}
+	if data.Item.Published != nil {
+		attachment.Timestamp = data.Item.Published.Unix()
+	}
+	return attachment
+}
+
+// newItemPost renders the post of an item in the format of the feed.
+// pageImage is the og:image of the page of the item, for cards.
+func (p *Plugin) newItemPost(feed *Feed, settings *ChannelSettings, page *gofeed.Feed, item *gofeed.Item, pageImage string) *model.Post {
+	post := &model.Post{ChannelId: feed.ChannelID}
+	if feed.Format == "card" {
+		model.ParseSlackAttachment(post, []*model.SlackAttachment{p.newAttachment(feed, page, item, pageImage)})
+		return post
+	}
+	post.Message = p.formatItem(feed, settings, page, item)
+	return post
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// AutocompleteFeedsPath serves the feeds of a channel to the autocomplete of
+// the arguments naming a feed.
+const AutocompleteFeedsPath = "/autocomplete/feeds"
+
+func listItems(values []string) []model.AutocompleteListItem {
+	items := make([]model.AutocompleteListItem, len(values))
+	for i, value := range values {
+		items[i] = model.AutocompleteListItem{Item: value}
+	}
+	return items
+}
+
+// addFeedArgument adds the feed argument of a subcommand, completed with the
+// feeds of the channel.
+func addFeedArgument(command *model.AutocompleteData, required bool) {
+	command.AddDynamicListArgument("The number or URL of a feed of the channel", AutocompleteFeedsPath[1:], required)
+}
+
+// addOptionArguments adds the options of /feed add and /feed edit.
+func addOptionArguments(command *model.AutocompleteData) {
+	command.AddNamedTextArgument("interval", "How often the feed is fetched: 2m, 1h, 1d, 1w, auto or default", "<interval>", "", false)
+	command.AddNamedStaticListArgument("delivery", "Post each item, or a digest of the new items", false, listItems(DeliveryModes))
+	command.AddNamedTextArgument("at", "When daily and weekly digests are posted", "<HH:MM>", `^\d{1,2}:\d{2}$`, false)
+	weekdays := []string{}
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		weekdays = append(weekdays, d.String())
+	}
+	command.AddNamedStaticListArgument("day", "The day weekly digests are posted", false, listItems(weekdays))
+	command.AddNamedTextArgument("timezone", "The timezone of digests, e.g. Europe/Berlin", "<zone>", "", false)
+	command.AddNamedStaticListArgument("thread", "Post items as replies to one root post, or to a root post per day", false, listItems(ThreadModes))
+	command.AddNamedStaticListArgument("format", "Post items as markdown text, or as cards", false, listItems(Formats))
+	command.AddNamedTextArgument("color", "The color of cards", "<#RRGGBB>", "", false)
+	command.AddNamedStaticListArgument("override", "Post items under the title and image of the feed", false, listItems([]string{"on", "off"}))
+	command.AddNamedTextArgument("username", "The name items are posted under, or default", "<name>", "", false)
+	command.AddNamedTextArgument("icon", "The URL of the icon items are posted with, or default", "<url>", "", false)
+}
+
+func getAutocompleteData() *model.AutocompleteData {
+	feed := model.NewAutocompleteData(CommandTrigger, "[command]", "Available commands: list, add, test, edit, del, pause, snooze, resume, template, filter, help")
+
+	list := model.NewAutocompleteData("list", "", "List the feeds of the channel with their state")
+	list.AddNamedTextArgument("all", "List the feeds of every channel, for system admins", "", "", false)
+	list.AddNamedTextArgument("page", "The page of the list to show", "<n>", `^\d+$`, false)
+	feed.AddCommand(list)
+
+	add := model.NewAutocompleteData("add", "<url> [<options>]", "Add a feed to the channel")
+	add.AddTextArgument("The URL of the feed, or of a page linking to it", "<url>", "")
+	addOptionArguments(add)
+	feed.AddCommand(add)
+
+	test := model.NewAutocompleteData("test", "<url> [<options>]", "Check a feed and preview its latest items, without adding it")
+	test.AddTextArgument("The URL of the feed", "<url>", "")
+	addOptionArguments(test)
+	feed.AddCommand(test)
+
+	edit := model.NewAutocompleteData("edit", "<url_or_number> <options>", "Change the options of a feed")
+	addFeedArgument(edit, true)
+	addOptionArguments(edit)
+	feed.AddCommand(edit)
+
+	del := model.NewAutocompleteData("del", "<url_or_number>", "Delete a feed")
+	addFeedArgument(del, true)
+	feed.AddCommand(del)
+
+	pause := model.NewAutocompleteData("pause", "<url_or_number>", "Stop fetching a feed until it is resumed")
+	addFeedArgument(pause, true)
+	feed.AddCommand(pause)
+
+	snooze := model.NewAutocompleteData("snooze", "<url_or_number> <duration>", "Stop fetching a feed for a while")
+	addFeedArgument(snooze, true)
+	snooze.AddTextArgument("How long the feed is snoozed, e.g. 8h or 2d", "<duration>", "")
+	feed.AddCommand(snooze)
+
+	resume := model.NewAutocompleteData("resume", "<url_or_number> [--skip-backlog]", "Resume a paused or snoozed feed")
+	addFeedArgument(resume, true)
+	resume.AddNamedTextArgument("skip-backlog", "Don't post the items published while the feed was silenced", "", "", false)
+	feed.AddCommand(resume)
+
+	template := model.NewAutocompleteData("template", "<url_or_number> [<template>|--reset]", "Show, set or reset the message template of a feed or of the channel")
+	addFeedArgument(template, false)
+	template.AddNamedTextArgument("channel", "Show, set or reset the default template of the channel instead", "[<template>]", "", false)
+	template.AddNamedTextArgument("timezone", "Set the timezone dates are formatted in", "<zone>", "", false)
+	template.AddNamedTextArgument("reset", "Go back to the default template", "", "", false)
+	feed.AddCommand(template)
+
+	filter := model.NewAutocompleteData("filter", "<url_or_number> [<action>]", "List, add, remove or test the filter rules of a feed")
+	addFeedArgument(filter, true)
+	filter.AddStaticListArgument("What to do with the rules", false, []model.AutocompleteListItem{
+		{Item: "list", HelpText: "List the filter rules"},
+		{Item: "include", Hint: "<field> <pattern>", HelpText: "Only post items matching a pattern"},
+		{Item: "exclude", Hint: "<field> <pattern>", HelpText: "Don't post items matching a pattern"},
+		{Item: "remove", Hint: "<n>", HelpText: "Remove a filter rule"},
+		{Item: "clear", HelpText: "Remove every filter rule"},
+		{Item: "test", HelpText: "Show which of the current items pass the filter rules"},
+	})
+	filter.AddStaticListArgument("The field of the items the pattern applies to", false, listItems(FilterFields))
+	filter.AddNamedTextArgument("group", "Rules of the same group must all match", "<n>", `^\d+$`, false)
+	feed.AddCommand(filter)
+
+	feed.AddCommand(model.NewAutocompleteData("help", "", "Show the help"))
+	return feed
+}
+
+// serveAutocompleteFeeds lists the feeds of the channel the user is typing
+// in, for the dynamic arguments of the command.
+func (p *Plugin) serveAutocompleteFeeds(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("Mattermost-User-Id")
+	channelID := r.URL.Query().Get("channel_id")
+	if userID == "" || channelID == "" {
+		http.Error(w, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+	if !p.API.HasPermissionToChannel(userID, channelID, model.PermissionReadChannel) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	items := []model.AutocompleteListItem{}
+	for _, feed := range p.LoadChannelFeeds(channelID) {
+		title := feed.Title
+		if title == "" {
+			title = feed.URL
+		}
+		items = append(items, model.AutocompleteListItem{
+			Item:     strconv.Itoa(feed.Number),
+			Hint:     title,
+			HelpText: feed.URL,
+		})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(items)
+	if err != nil {
+		p.client.Log.Error("Error writing response: " + err.Error())
+	}
+}
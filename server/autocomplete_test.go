@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAutocompleteDataIsValid(t *testing.T) {
+	require.NoError(t, getAutocompleteData().IsValid())
+}
+
+func TestServeAutocompleteFeeds(t *testing.T) {
+	p, api := setupPlugin(t)
+
+	api.On("HasPermissionToChannel", "user1", "channel1", model.PermissionReadChannel).Return(true)
+	api.On("HasPermissionToChannel", "user2", "channel1", model.PermissionReadChannel).Return(false)
+	api.On("KVGet", "channel_channel1").Return(mustMarshal(t, []string{"feed1", "feed3"}), nil)
+	api.On("KVGet", "feed_feed1").Return(mustMarshal(t, &Feed{ID: "feed1", Number: 1, URL: "https://example.com/a", Title: "A"}), nil)
+	api.On("KVGet", "feed_feed3").Return(mustMarshal(t, &Feed{ID: "feed3", Number: 3, URL: "https://example.com/c"}), nil)
+
+	request := func(userID string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, AutocompleteFeedsPath+"?channel_id=channel1", nil)
+		if userID != "" {
+			r.Header.Set("Mattermost-User-Id", userID)
+		}
+		w := httptest.NewRecorder()
+		p.ServeHTTP(nil, w, r)
+		return w
+	}
+
+	w := request("user1")
+	require.Equal(t, http.StatusOK, w.Code)
+	var items []model.AutocompleteListItem
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &items))
+	assert.Equal(t, []model.AutocompleteListItem{
+		{Item: "1", Hint: "A", HelpText: "https://example.com/a"},
+		{Item: "3", Hint: "https://example.com/c", HelpText: "https://example.com/c"},
+	}, items)
+
+	assert.Equal(t, http.StatusForbidden, request("user2").Code)
+	assert.Equal(t, http.StatusUnauthorized, request("").Code)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"net/url"
 
 	_ "embed"
 
@@ -28,6 +29,13 @@ func (p *Plugin) SetFeedBotProfileImage() error {
 	return p.client.User.SetProfileImage(p.botID, bytes.NewReader(botImage))
 }
 
+// CreateBotPost creates post as the bot.
+func (p *Plugin) CreateBotPost(post *model.Post) (*model.Post, error) {
+	post.UserId = p.botID
+	err := p.client.Post.CreatePost(post)
+	return post, err
+}
+
 func (p *Plugin) BotPost(channelID string, text string) {
 	err := p.client.Post.CreatePost(&model.Post{
 		UserId:    p.botID,
@@ -38,3 +46,55 @@ func (p *Plugin) BotPost(channelID string, text string) {
 		p.client.Log.Error("Error posting message: " + err.Error())
 	}
 }
+
+// applyOverrides sets the display name and icon of a post to those of the
+// feed, if the feed asks for it and the server allows overrides.
+func (p *Plugin) applyOverrides(feed *Feed, post *model.Post) {
+	config := p.API.GetConfig()
+	if !feed.Override || config == nil {
+		return
+	}
+	settings := config.ServiceSettings
+	overridden := false
+	if settings.EnablePostUsernameOverride != nil && *settings.EnablePostUsernameOverride {
+		username := feed.Username
+		if username == "" {
+			username = feed.Title
+		}
+		if username != "" {
+			post.AddProp(model.PostPropsOverrideUsername, username)
+			overridden = true
+		}
+	}
+	if settings.EnablePostIconOverride != nil && *settings.EnablePostIconOverride {
+		iconURL := feed.IconURL
+		if iconURL == "" {
+			iconURL = feedIconURL(feed)
+		}
+		if iconURL != "" {
+			post.AddProp(model.PostPropsOverrideIconURL, iconURL)
+			overridden = true
+		}
+	}
+	if overridden {
+		// the webapp only honors overrides on posts from webhooks
+		post.AddProp(model.PostPropsFromWebhook, "true")
+	}
+}
+
+// feedIconURL returns the <image> of a feed, falling back to the favicon of
+// its site.
+func feedIconURL(feed *Feed) string {
+	if feed.ImageURL != "" {
+		return feed.ImageURL
+	}
+	site := feed.SiteURL
+	if site == "" {
+		site = feed.URL
+	}
+	u, err := url.Parse(site)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host + "/favicon.ico"
+}
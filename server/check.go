@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mmcdole/gofeed"
+	"golang.org/x/net/html/charset"
+)
+
+// TestPreviewItems is the number of items /feed test renders.
+const TestPreviewItems = 3
+
+var feedTypeNames = map[gofeed.FeedType]string{
+	gofeed.FeedTypeRSS:  "RSS",
+	gofeed.FeedTypeAtom: "Atom",
+	gofeed.FeedTypeJSON: "JSON Feed",
+}
+
+// TestFeed handles /feed test: it fetches and parses a feed the way the job
+// does, and shows what would be posted without subscribing to it.
+func (p *Plugin) TestFeed(args *model.CommandArgs, url string, flags map[string]string) *model.CommandResponse {
+	url = normalizeURL(url)
+	err := p.checkFeedURL(url)
+	if err != nil {
+		return response("Error: " + err.Error())
+	}
+	feed := &Feed{URL: url, ChannelID: args.ChannelId}
+	err = applyFlags(feed, flags, p.getChannelTimezone(args.ChannelId))
+	if err != nil {
+		return response("Error: " + err.Error())
+	}
+	result, err := p.fetchURL(url, p.getConfiguration().GetFetchTimeout())
+	if err != nil {
+		return response("Error: " + url + " could not be fetched, " + describeFetchError(err, p.getConfiguration().GetFetchTimeout()))
+	}
+	feedType := gofeed.DetectFeedType(bytes.NewReader(result.Body))
+	page, err := gofeed.NewParser().ParseString(string(result.Body))
+	if err != nil {
+		text := "Error: " + url + " could not be parsed, " + describeParseError(result.Body, feedType, err)
+		if links := discoverFeeds(result.Body, result.URL); isHTML(result.Body) && len(links) > 0 {
+			text += "\n\n" + formatFeedLinks(url, links)
+		}
+		return response(text)
+	}
+	feed.Title = page.Title
+	feed.SiteURL = page.Link
+	if page.Image != nil {
+		feed.ImageURL = page.Image.URL
+	}
+	dated, identified := 0, 0
+	for _, item := range page.Items {
+		if getDate(item) != nil {
+			dated++
+		}
+		if item.GUID != "" {
+			identified++
+		}
+	}
+	text := fmt.Sprintf("**%s** is a valid feed.\n\n", url) +
+		"| | |\n|:--|:--|\n" +
+		fmt.Sprintf("| Format | %s %s |\n", feedTypeNames[feedType], page.FeedVersion) +
+		fmt.Sprintf("| Title | %s |\n", escapeMarkdown(page.Title)) +
+		fmt.Sprintf("| Items | %d |\n", len(page.Items)) +
+		fmt.Sprintf("| Items with a date | %d |\n", dated) +
+		fmt.Sprintf("| Items with a GUID | %d |\n", identified)
+	if len(page.Items) > 0 && dated < len(page.Items) && identified < len(page.Items) {
+		text += "\nSome items have neither a date nor a GUID, they are told apart by their link or content."
+	}
+	resp := response(text)
+	items := latestItems(page.Items, TestPreviewItems)
+	if len(items) == 0 {
+		return resp
+	}
+	resp.Text += fmt.Sprintf("\n\nThe latest %d items, as they would be posted:", len(items))
+	settings, err := p.GetChannelSettings(args.ChannelId)
+	if err != nil {
+		p.client.Log.Error("Error loading channel settings: " + err.Error())
+	}
+	for _, item := range items {
+		// the pages of the items are not fetched for their og:image, the
+		// preview must not wait for them
+		post := p.newItemPost(feed, settings, page, item, "")
+		resp.ExtraResponses = append(resp.ExtraResponses, &model.CommandResponse{
+			Text:        post.Message,
+			Attachments: post.Attachments(),
+		})
+	}
+	return resp
+}
+
+// latestItems returns the n most recent items, oldest first as they are
+// posted. Undated items keep their order in the feed, after dated ones.
+func latestItems(items []*gofeed.Item, n int) []*gofeed.Item {
+	latest := slices.Clone(items)
+	slices.SortStableFunc(latest, func(a, b *gofeed.Item) int {
+		dateA, dateB := getDate(a), getDate(b)
+		switch {
+		case dateA == nil && dateB == nil:
+			return 0
+		case dateA == nil:
+			return 1
+		case dateB == nil:
+			return -1
+		}
+		return dateB.Compare(*dateA)
+	})
+	latest = latest[:min(n, len(latest))]
+	slices.Reverse(latest)
+	return latest
+}
+
+// describeFetchError explains why a feed could not be fetched. It never
+// includes the addresses a host resolved to, which may be internal.
+func describeFetchError(err error, timeout time.Duration) string {
+	var statusErr *httpStatusError
+	var certErr *tls.CertificateVerificationError
+	var headerErr tls.RecordHeaderError
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var opErr *net.OpError
+	switch {
+	case errors.As(err, &statusErr):
+		return "the server answered " + statusErr.Status
+	case errors.Is(err, ErrDomainNotAllowed):
+		return "feeds from this domain are not allowed on this server"
+	case errors.Is(err, ErrAddressNotAllowed):
+		return "the server is on a network feeds are not allowed from"
+	case errors.As(err, &certErr):
+		return "the TLS certificate of the server is not valid: " + certErr.Err.Error()
+	case errors.As(err, &headerErr):
+		return "the TLS handshake failed, the server doesn't speak HTTPS on this port"
+	case errors.As(err, &dnsErr):
+		return "the host " + dnsErr.Name + " could not be found"
+	case errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout():
+		return fmt.Sprintf("the server did not answer within %s", timeout)
+	case errors.As(err, &opErr):
+		return "the connection to the server failed: " + opErr.Err.Error()
+	}
+	return err.Error()
+}
+
+// describeParseError explains why a fetched body is not a feed, with the
+// position of the error in the document when it is malformed.
+func describeParseError(body []byte, feedType gofeed.FeedType, err error) string {
+	trimmed := bytes.TrimSpace(body)
+	switch {
+	case feedType == gofeed.FeedTypeUnknown && isHTML(body):
+		return "this is an HTML page, not a feed. Look for a link to its RSS or Atom feed on the page."
+	case feedType == gofeed.FeedTypeJSON || bytes.HasPrefix(trimmed, []byte("{")):
+		if message := jsonError(body); message != "" {
+			return message
+		}
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		if message := xmlError(body); message != "" {
+			return message
+		}
+	}
+	if feedType == gofeed.FeedTypeUnknown {
+		return "this is not an RSS, Atom or JSON feed"
+	}
+	return err.Error()
+}
+
+// jsonError returns the first syntax error of a JSON document, if any.
+func jsonError(body []byte) string {
+	var syntaxErr *json.SyntaxError
+	if !errors.As(json.Unmarshal(body, new(any)), &syntaxErr) {
+		return ""
+	}
+	line, column := textPosition(body, syntaxErr.Offset)
+	return fmt.Sprintf("invalid JSON at line %d, column %d: %s", line, column, syntaxErr.Error())
+}
+
+// xmlError returns the first syntax error of an XML document, if any. The
+// decoder is as lenient as the one of gofeed.
+func xmlError(body []byte) string {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	decoder.Strict = false
+	decoder.CharsetReader = charset.NewReaderLabel
+	for {
+		_, err := decoder.Token()
+		if err == io.EOF {
+			return ""
+		}
+		if err != nil {
+			message := err.Error()
+			var syntaxErr *xml.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				message = syntaxErr.Msg
+			}
+			line, column := decoder.InputPos()
+			return fmt.Sprintf("invalid XML at line %d, column %d: %s", line, column, message)
+		}
+	}
+}
+
+// isHTML reports whether body is an HTML page.
+func isHTML(body []byte) bool {
+	return strings.HasPrefix(http.DetectContentType(body), "text/html")
+}
+
+// textPosition returns the 1-based line and column of a byte offset.
+func textPosition(body []byte, offset int64) (int, int) {
+	before := body[:min(int(offset), len(body))]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n')
+	return line, column
+}
@@ -1,13 +1,18 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	neturl "net/url"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/mattermost/mattermost/server/public/model"
 	"github.com/mattermost/mattermost/server/public/plugin"
+	"github.com/mmcdole/gofeed"
 )
 
 const CommandTrigger = "feed"
@@ -19,24 +24,76 @@ func (p *Plugin) ExecuteCommand(c *plugin.Context, args *model.CommandArgs) (*mo
 		return responseHelp(), nil
 	}
 	subCommand := commands[1]
+	params, flags := parseArgs(commands[2:])
+	if manageCommands[subCommand] {
+		if resp := p.checkManagePermission(args); resp != nil {
+			return resp, nil
+		}
+	}
 	switch subCommand {
 	case "help":
 		return responseHelp(), nil
 	case "list":
-		return p.ListFeeds(args), nil
+		return p.ListFeeds(args, flags), nil
+	case "template":
+		return p.TemplateCommand(args), nil
+	case "filter":
+		return p.FilterCommand(args, commands[2:]), nil
 	}
-	if len(commands) != 3 {
+	if subCommand == "snooze" && len(params) == 2 {
+		return p.SnoozeFeed(args, params[0], params[1]), nil
+	}
+	if len(params) != 1 {
 		return responseHelp(), nil
 	}
 	switch subCommand {
 	case "add":
-		return p.AddFeed(args, commands[2]), nil
+		return p.AddFeed(args, params[0], flags), nil
+	case "test":
+		return p.TestFeed(args, params[0], flags), nil
 	case "del":
-		return p.DelFeed(args, commands[2]), nil
+		return p.DelFeed(args, params[0]), nil
+	case "edit":
+		return p.EditFeed(args, params[0], flags), nil
+	case "pause":
+		return p.PauseFeed(args, params[0]), nil
+	case "resume":
+		return p.ResumeFeed(args, params[0], flags), nil
 	}
 	return responseHelp(), nil
 }
 
+// boolFlags are the options that take no value.
+var boolFlags = map[string]bool{
+	"all":          true,
+	"skip-backlog": true,
+}
+
+// parseArgs splits command arguments into positional parameters and
+// --name value (or --name=value) options.
+func parseArgs(fields []string) ([]string, map[string]string) {
+	params := []string{}
+	flags := map[string]string{}
+	for i := 0; i < len(fields); i++ {
+		name, ok := strings.CutPrefix(fields[i], "--")
+		if !ok {
+			params = append(params, fields[i])
+			continue
+		}
+		if name, value, ok := strings.Cut(name, "="); ok {
+			flags[name] = value
+			continue
+		}
+		if boolFlags[name] || i+1 == len(fields) {
+			flags[name] = ""
+			continue
+		}
+		flags[name] = fields[i+1]
+		i++
+	}
+	return params, flags
+}
+
 func response(text string) *model.CommandResponse {
 	return &model.CommandResponse{
 		Text: text,
@@ -46,14 +103,73 @@ func response(text string) *model.CommandResponse {
 func responseHelp() *model.CommandResponse {
 	return response("```" + `
 Usage: /feed <command> [args]
-/feed list
-	List all feeds
-/feed add <url>
+/feed list [--page <n>]
+	List the feeds of the channel with their state
+/feed list --all [--page <n>]
+	List the feeds of every channel, for system admins
+/feed add <url> [<options>]
 	Add a feed
-/feed del <url_or_index>
+/feed test <url> [<options>]
+	Check a feed and preview its latest items, without adding it
+/feed edit <url_or_number> <options>
+	Change the options of a feed
+/feed del <url_or_number>
 	Delete a feed
+/feed pause <url_or_number>
+	Stop fetching a feed until it is resumed
+/feed snooze <url_or_number> <duration>
+	Stop fetching a feed for a while, e.g. 8h or 2d
+/feed resume <url_or_number> [--skip-backlog]
+	Resume a paused or snoozed feed, posting the items it missed unless
+	--skip-backlog is given
+/feed template <url_or_number> [<template>|--reset]
+	Show, set or reset the message template of a feed
+/feed template --channel [<template>|--reset]
+	Show, set or reset the default message template of the channel
+/feed template --timezone <zone>
+	Set the timezone dates are formatted in, e.g. Europe/Berlin
+/feed filter <url_or_number> [list]
+	List the filter rules of a feed
+/feed filter <url_or_number> include|exclude <field> <pattern> [--group <n>]
+	Add a filter rule on title, summary, author, categories or link
+/feed filter <url_or_number> remove <n>|clear
+	Remove one or all filter rules
+/feed filter <url_or_number> test
+	Show which of the current items pass the filter rules
 /feed help
 	Show this help
+
+<options> are:
+	--interval <interval>
+		How often the feed is fetched
+	--delivery immediate|hourly|daily|weekly
+		Post each item, or a digest of the new items
+	--at <HH:MM> --day <weekday> --timezone <zone>
+		When daily and weekly digests are posted
+	--thread off|feed|daily
+		Post items as replies to one root post, or to a root post per day
+	--format plain|card [--color <#RRGGBB>]
+		Post items as markdown text, or as cards
+	--override on|off [--username <name>] [--icon <url>]
+		Post items under the title and image of the feed, or the given
+		name and icon, if the server allows overrides
+
+<url_or_number> is the URL of a feed of the channel, or its number in
+/feed list, which stays the same when other feeds are deleted.
+
+<interval> is a duration such as 2m, 1h, 1d or 1w, "auto" to follow
+the hints of the publisher, or "default" for the server default.
+
+<template> is a Go text/template with .Item (Title, Link, Author, Summary,
+Content, Categories, Published, Enclosures, Image) and .Feed (Title, Description,
+Link, URL), and the functions truncate, escape, join and date, e.g.
+	**{{escape .Item.Title}}** ({{date "2006-01-02" .Item.Published}})
+	{{truncate 200 .Item.Summary}}
+	{{.Item.Link}}
+
+<pattern> is a case insensitive keyword, or a regular expression written
+as /regex/. Rules in the same group must all match, and an item is posted
+if it matches any include group (when there are some) and no exclude group.
 ` + "```")
 }
 
@@ -70,6 +186,8 @@ func (p *Plugin) RegisterFeedCommand() error {
 		Trigger:          CommandTrigger,
 		AutoComplete:     true,
 		AutoCompleteDesc: CommandDescription,
+		AutoCompleteHint: "[command]",
+		AutocompleteData: getAutocompleteData(),
 	})
 }
 
@@ -77,52 +195,705 @@ func (p *Plugin) UnregisterFeedCommand() error {
 	return p.client.SlashCommand.Unregister("", CommandTrigger)
 }
 
-func (p *Plugin) ListFeeds(args *model.CommandArgs) *model.CommandResponse {
-	feeds := p.LoadFeeds()
-	text := "Feeds in this channel:\n\n"
-	for _, feed := range feeds {
-		if feed.ChannelID != args.ChannelId {
-			continue
+// applyFlags sets the options given to add or edit on the feed. Digests
+// default to DefaultDigestTime, on Mondays, in timezone.
+func applyFlags(feed *Feed, flags map[string]string, timezone string) error {
+	delivery := feed.Delivery
+	for name, value := range flags {
+		switch name {
+		case "interval":
+			switch value {
+			case "auto":
+				feed.Interval = 0
+				feed.Adaptive = true
+			case "default":
+				feed.Interval = 0
+				feed.Adaptive = false
+			default:
+				interval, err := parseInterval(value)
+				if err != nil {
+					return err
+				}
+				feed.Interval = int(interval / time.Minute)
+				feed.Adaptive = false
+			}
+		case "delivery":
+			if !slices.Contains(DeliveryModes, value) {
+				return fmt.Errorf("unknown delivery %s, use one of %s", value, strings.Join(DeliveryModes, ", "))
+			}
+			feed.Delivery = strings.TrimPrefix(value, "immediate")
+		case "thread":
+			if !slices.Contains(ThreadModes, value) {
+				return fmt.Errorf("unknown thread mode %s, use one of %s", value, strings.Join(ThreadModes, ", "))
+			}
+			feed.Thread = strings.TrimPrefix(value, "off")
+		case "format":
+			if !slices.Contains(Formats, value) {
+				return fmt.Errorf("unknown format %s, use one of %s", value, strings.Join(Formats, ", "))
+			}
+			feed.Format = strings.TrimPrefix(value, "plain")
+		case "color":
+			if value != "" && !colorPattern.MatchString(value) {
+				return fmt.Errorf("invalid color %s, use #RRGGBB", value)
+			}
+			feed.Color = value
+		case "override":
+			switch value {
+			case "on":
+				feed.Override = true
+			case "off":
+				feed.Override = false
+			default:
+				return fmt.Errorf("unknown override %s, use on or off", value)
+			}
+		case "username":
+			feed.Username = strings.TrimPrefix(value, "default")
+			// an explicit --override wins, whatever order flags come in
+			if _, ok := flags["override"]; !ok {
+				feed.Override = true
+			}
+		case "icon":
+			if value != "default" && validateURL(value) != nil {
+				return fmt.Errorf("%s is not a valid icon URL", value)
+			}
+			feed.IconURL = strings.TrimPrefix(value, "default")
+			if _, ok := flags["override"]; !ok {
+				feed.Override = true
+			}
+		case "at":
+			_, _, err := parseDigestTime(value)
+			if err != nil {
+				return err
+			}
+			feed.DigestTime = value
+		case "day":
+			day, err := parseWeekday(value)
+			if err != nil {
+				return err
+			}
+			feed.DigestWeekday = int(day)
+		case "timezone":
+			_, err := time.LoadLocation(value)
+			if err != nil {
+				return fmt.Errorf("unknown timezone %s", value)
+			}
+			feed.DigestTimezone = value
+		default:
+			return fmt.Errorf("unknown option --%s", name)
 		}
-		text += "1. " + feed.URL + "\n"
 	}
-	return response(text)
+	if feed.Delivery == "" {
+		return nil
+	}
+	if feed.DigestTime == "" {
+		feed.DigestTime = DefaultDigestTime
+	}
+	if feed.DigestTimezone == "" {
+		feed.DigestTimezone = timezone
+	}
+	if _, ok := flags["day"]; !ok && feed.Delivery == "weekly" && delivery != "weekly" {
+		feed.DigestWeekday = int(time.Monday)
+	}
+	// let the digest job schedule the new delivery
+	feed.NextDigest = 0
+	return nil
 }
 
-func (p *Plugin) AddFeed(args *model.CommandArgs, url string) *model.CommandResponse {
-	feeds := p.LoadFeeds()
-	feeds = append(feeds,
-		Feed{
-			URL:       url,
-			ChannelID: args.ChannelId,
-			Updated:   time.Now().Unix(),
-		})
-	success, _ := p.SaveFeeds(feeds)
-	if success {
-		userName := p.GetUserName(args.UserId)
-		p.BotPost(args.ChannelId,
-			"**New feed added!**\n\n"+url+" by @"+userName)
-		return response("")
+// getChannelTimezone returns the timezone of the channel, UTC by default.
+func (p *Plugin) getChannelTimezone(channelID string) string {
+	settings, err := p.GetChannelSettings(channelID)
+	if err != nil || settings.Timezone == "" {
+		return "UTC"
+	}
+	return settings.Timezone
+}
+
+func (p *Plugin) AddFeed(args *model.CommandArgs, url string, flags map[string]string) *model.CommandResponse {
+	url = normalizeURL(url)
+	err := p.checkFeedURL(url)
+	if err != nil {
+		return response("Error: " + err.Error())
+	}
+	feed := &Feed{
+		URL:       url,
+		ChannelID: args.ChannelId,
+		CreatorID: args.UserId,
+		Updated:   time.Now().Unix(),
+	}
+	err = applyFlags(feed, flags, p.getChannelTimezone(args.ChannelId))
+	if err != nil {
+		return response("Error: " + err.Error())
+	}
+	if existing := p.findChannelFeed(args.ChannelId, url); existing != nil {
+		return response(fmt.Sprintf("Error: %s is already in this channel as #%d", url, existing.Number))
+	}
+	timeout := min(p.getConfiguration().GetFetchTimeout(), AddFetchTimeout)
+	result, err := p.fetchURL(url, timeout)
+	if err != nil {
+		return response("Error: " + url + " could not be fetched, " + describeFetchError(err, timeout))
+	}
+	// A page rather than a feed is replaced with the feed it links to.
+	if isHTML(result.Body) {
+		links := discoverFeeds(result.Body, result.URL)
+		switch len(links) {
+		case 0:
+			return response("Error: " + url + " is an HTML page that doesn't link to any feed")
+		case 1:
+			feed.URL = normalizeURL(links[0].URL)
+		default:
+			return response(formatFeedLinks(url, links))
+		}
+		err = p.checkFeedURL(feed.URL)
+		if err != nil {
+			return response("Error: " + err.Error())
+		}
+		if existing := p.findChannelFeed(args.ChannelId, feed.URL); existing != nil {
+			return response(fmt.Sprintf("Error: %s links to %s, which is already in this channel as #%d", url, feed.URL, existing.Number))
+		}
+		result, err = p.fetchURL(feed.URL, timeout)
+		if err != nil {
+			return response("Error: " + feed.URL + " could not be fetched, " + describeFetchError(err, timeout))
+		}
+	}
+	page, err := gofeed.NewParser().ParseString(string(result.Body))
+	if err != nil {
+		feedType := gofeed.DetectFeedType(bytes.NewReader(result.Body))
+		return response("Error: " + feed.URL + " could not be parsed, " + describeParseError(result.Body, feedType, err))
+	}
+	feed.Title = page.Title
+	feed.SiteURL = page.Link
+	if page.Image != nil {
+		feed.ImageURL = page.Image.URL
 	}
-	return response("Error: unable to save feeds")
+	// the items already in the feed are never posted
+	feed.Seen = pruneSeen(nil, page.Items)
+	feed.LastSuccessAt = time.Now().Unix()
+	err = p.CreateFeed(feed)
+	if err != nil {
+		p.client.Log.Error("Error saving feed: " + err.Error())
+		return response("Error: unable to save feeds")
+	}
+	title := fmt.Sprintf("#%d %s", feed.Number, feed.URL)
+	if feed.Title != "" {
+		title = fmt.Sprintf("#%d %s\n%s", feed.Number, escapeMarkdown(feed.Title), feed.URL)
+	}
+	userName := p.GetUserName(args.UserId)
+	p.BotPost(args.ChannelId,
+		"**New feed added!**\n\n"+title+" by @"+userName)
+	return response("")
+}
+
+// normalizeURL cleans up a URL typed by a user. Surrounding angle brackets
+// are dropped, https is assumed when there is no scheme, the scheme and host
+// are lowercased, and default ports and fragments are removed.
+func normalizeURL(raw string) string {
+	raw = strings.Trim(strings.TrimSpace(raw), "<>")
+	if !strings.Contains(raw, "://") {
+		raw = "https://" + raw
+	}
+	u, err := neturl.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	if port := u.Port(); u.Scheme == "http" && port == "80" || u.Scheme == "https" && port == "443" {
+		u.Host = strings.TrimSuffix(u.Host, ":"+port)
+	}
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+	return u.String()
+}
+
+// validateURL checks that url is an absolute http or https URL.
+func validateURL(url string) error {
+	u, err := neturl.Parse(url)
+	if err != nil || u.Hostname() == "" {
+		return errors.New(url + " is not a valid URL")
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("only http and https URLs are supported")
+	}
+	return nil
 }
 
-func (p *Plugin) DelFeed(args *model.CommandArgs, urlOrIndex string) *model.CommandResponse {
-	feeds := p.LoadFeeds()
+// checkFeedURL checks that a feed may be fetched from url.
+func (p *Plugin) checkFeedURL(url string) error {
+	err := validateURL(url)
+	if err != nil {
+		return err
+	}
+	u, _ := neturl.Parse(url)
+	if !p.getConfiguration().IsDomainAllowed(u.Hostname()) {
+		return errors.New("feeds from " + url + " are not allowed on this server")
+	}
+	return nil
+}
+
+// findChannelFeed returns the feed of the channel with the given URL or
+// number.
+func (p *Plugin) findChannelFeed(channelID string, urlOrNumber string) *Feed {
+	return matchFeed(p.LoadChannelFeeds(channelID), urlOrNumber)
+}
+
+// matchFeed returns the feed with the given URL or number, written as 2 or
+// #2.
+func matchFeed(feeds []Feed, urlOrNumber string) *Feed {
+	number, err := strconv.Atoi(strings.TrimPrefix(urlOrNumber, "#"))
+	if err != nil {
+		number = 0
+	}
+	url := normalizeURL(urlOrNumber)
 	for i, feed := range feeds {
-		if feed.ChannelID != args.ChannelId {
-			continue
+		if number > 0 && feed.Number == number || feed.URL == urlOrNumber || feed.URL == url {
+			return &feeds[i]
+		}
+	}
+	return nil
+}
+
+func responseNotFound(urlOrNumber string) *model.CommandResponse {
+	return response(urlOrNumber + " is not found in this channel. Please check the URL or number with `/feed list` and try again.")
+}
+
+func (p *Plugin) EditFeed(args *model.CommandArgs, urlOrNumber string, flags map[string]string) *model.CommandResponse {
+	if len(flags) == 0 {
+		return responseHelp()
+	}
+	feed := p.findChannelFeed(args.ChannelId, urlOrNumber)
+	if feed == nil {
+		return responseNotFound(urlOrNumber)
+	}
+	timezone := p.getChannelTimezone(args.ChannelId)
+	err := applyFlags(&Feed{}, flags, timezone)
+	if err != nil {
+		return response("Error: " + err.Error())
+	}
+	updated, err := p.UpdateFeed(feed.ID, func(stored *Feed) error {
+		if _, ok := flags["interval"]; ok {
+			// fetch soon so the new schedule takes effect
+			stored.NextFetch = 0
+		}
+		return applyFlags(stored, flags, timezone)
+	})
+	if errors.Is(err, ErrFeedNotFound) {
+		return responseNotFound(urlOrNumber)
+	}
+	if err != nil {
+		return response("Error: " + err.Error())
+	}
+	if feed.Delivery != "" && updated.Delivery == "" {
+		// deliver what was queued for the digest right away
+		p.postDigest(updated)
+	}
+	return response(fmt.Sprintf("%s is now fetched at interval: %s, delivery: %s, threads: %s",
+		updated.URL, formatInterval(updated), formatDelivery(updated), formatThread(updated)))
+}
+
+// PauseFeed handles /feed pause.
+func (p *Plugin) PauseFeed(args *model.CommandArgs, urlOrNumber string) *model.CommandResponse {
+	feed := p.findChannelFeed(args.ChannelId, urlOrNumber)
+	if feed == nil {
+		return responseNotFound(urlOrNumber)
+	}
+	if feed.Paused {
+		return response(feed.URL + " is already paused.")
+	}
+	userName := p.GetUserName(args.UserId)
+	_, err := p.UpdateFeed(feed.ID, func(stored *Feed) error {
+		stored.Paused = true
+		stored.PausedReason = "@" + userName + " paused it"
+		return nil
+	})
+	if errors.Is(err, ErrFeedNotFound) {
+		return responseNotFound(urlOrNumber)
+	}
+	if err != nil {
+		p.client.Log.Error("Error saving feed: " + err.Error())
+		return response("Error: unable to save feeds")
+	}
+	p.BotPost(args.ChannelId, "**Feed paused!**\n\n"+feed.URL+" by @"+userName)
+	return response("")
+}
+
+// SnoozeFeed handles /feed snooze.
+func (p *Plugin) SnoozeFeed(args *model.CommandArgs, urlOrNumber string, duration string) *model.CommandResponse {
+	feed := p.findChannelFeed(args.ChannelId, urlOrNumber)
+	if feed == nil {
+		return responseNotFound(urlOrNumber)
+	}
+	interval, err := parseInterval(duration)
+	if err != nil {
+		return response("Error: " + err.Error())
+	}
+	until := time.Now().Add(interval)
+	_, err = p.UpdateFeed(feed.ID, func(stored *Feed) error {
+		stored.SnoozeUntil = until.Unix()
+		return nil
+	})
+	if errors.Is(err, ErrFeedNotFound) {
+		return responseNotFound(urlOrNumber)
+	}
+	if err != nil {
+		p.client.Log.Error("Error saving feed: " + err.Error())
+		return response("Error: unable to save feeds")
+	}
+	location, err := time.LoadLocation(p.getChannelTimezone(args.ChannelId))
+	if err != nil {
+		location = time.UTC
+	}
+	userName := p.GetUserName(args.UserId)
+	p.BotPost(args.ChannelId, "**Feed snoozed!**\n\n"+feed.URL+" until "+until.In(location).Format("2006-01-02 15:04 MST")+" by @"+userName)
+	return response("")
+}
+
+// ResumeFeed handles /feed resume. The items published while the feed was
+// silenced are posted on its next fetch, unless --skip-backlog is given:
+// they are then fetched and marked as seen right away.
+func (p *Plugin) ResumeFeed(args *model.CommandArgs, urlOrNumber string, flags map[string]string) *model.CommandResponse {
+	feed := p.findChannelFeed(args.ChannelId, urlOrNumber)
+	if feed == nil {
+		return responseNotFound(urlOrNumber)
+	}
+	for name := range flags {
+		if name != "skip-backlog" {
+			return response("Error: unknown option --" + name)
+		}
+	}
+	if !isSilenced(feed, time.Now()) {
+		return response(feed.URL + " is neither paused nor snoozed.")
+	}
+	_, skipBacklog := flags["skip-backlog"]
+	var page *gofeed.Feed
+	if skipBacklog {
+		var err error
+		page, err = p.fetchPage(feed.URL)
+		if err != nil {
+			return response("Error: the backlog of " + feed.URL + " could not be skipped, it could not be fetched: " + err.Error())
+		}
+	}
+	_, err := p.UpdateFeed(feed.ID, func(stored *Feed) error {
+		stored.Paused = false
+		stored.PausedReason = ""
+		stored.SnoozeUntil = 0
+		stored.Failures = 0
+		stored.NextFetch = 0
+		if page != nil {
+			stored.Seen = pruneSeen(stored.Seen, page.Items)
+			stored.Updated = time.Now().Unix()
+		}
+		return nil
+	})
+	if errors.Is(err, ErrFeedNotFound) {
+		return responseNotFound(urlOrNumber)
+	}
+	if err != nil {
+		p.client.Log.Error("Error saving feed: " + err.Error())
+		return response("Error: unable to save feeds")
+	}
+	userName := p.GetUserName(args.UserId)
+	p.BotPost(args.ChannelId, "**Feed resumed!**\n\n"+feed.URL+" by @"+userName)
+	return response("")
+}
+
+func (p *Plugin) DelFeed(args *model.CommandArgs, urlOrNumber string) *model.CommandResponse {
+	feed := p.findChannelFeed(args.ChannelId, urlOrNumber)
+	if feed == nil {
+		return responseNotFound(urlOrNumber)
+	}
+	if resp := p.checkDeletePermission(args, feed); resp != nil {
+		return resp
+	}
+	err := p.DeleteFeed(feed)
+	if err != nil {
+		p.client.Log.Error("Error deleting feed: " + err.Error())
+		return response("Error: unable to save feeds")
+	}
+	userName := p.GetUserName(args.UserId)
+	p.BotPost(args.ChannelId, "**Feed deleted!**\n\n"+feed.URL+" by @"+userName)
+	return response("")
+}
+
+// cutField splits s after its first whitespace separated field, keeping the
+// line breaks of the rest.
+func cutField(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexAny(s, " \t\n")
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], strings.TrimSpace(s[i:])
+}
+
+// TemplateCommand handles /feed template. The template is taken verbatim
+// from the command, line breaks included.
+func (p *Plugin) TemplateCommand(args *model.CommandArgs) *model.CommandResponse {
+	_, rest := cutField(args.Command)
+	_, rest = cutField(rest)
+	target, text := cutField(rest)
+	switch target {
+	case "":
+		return responseHelp()
+	case "--channel":
+		return p.channelTemplate(args, text)
+	case "--timezone":
+		return p.channelTimezone(args, text)
+	}
+	feed := p.findChannelFeed(args.ChannelId, target)
+	if feed == nil {
+		return responseNotFound(target)
+	}
+	settings, err := p.GetChannelSettings(args.ChannelId)
+	if err != nil {
+		return response("Error: unable to load channel settings")
+	}
+	if text == "" {
+		current, _ := p.itemTemplate(feed, settings)
+		return response("Template of " + feed.URL + ":\n" + codeBlock(current) + p.previewTemplate(feed, settings))
+	}
+	if text == "--reset" {
+		text = ""
+	}
+	preview := *feed
+	preview.Template = text
+	if text != "" {
+		_, err = parseTemplate(text)
+		if err != nil {
+			return response("Error: " + err.Error())
+		}
+	}
+	rendered := p.previewTemplate(&preview, settings)
+	if strings.HasPrefix(rendered, templateErrorPrefix) {
+		return response(rendered)
+	}
+	_, err = p.UpdateFeed(feed.ID, func(stored *Feed) error {
+		stored.Template = text
+		return nil
+	})
+	if errors.Is(err, ErrFeedNotFound) {
+		return responseNotFound(target)
+	}
+	if err != nil {
+		p.client.Log.Error("Error saving feed: " + err.Error())
+		return response("Error: unable to save feeds")
+	}
+	return response("Template of " + feed.URL + " saved." + rendered)
+}
+
+func (p *Plugin) channelTemplate(args *model.CommandArgs, text string) *model.CommandResponse {
+	settings, err := p.GetChannelSettings(args.ChannelId)
+	if err != nil {
+		return response("Error: unable to load channel settings")
+	}
+	// preview against the first feed of the channel without its own template
+	var feed *Feed
+	for _, f := range p.LoadChannelFeeds(args.ChannelId) {
+		if f.Template == "" {
+			feed = &f
+			break
+		}
+	}
+	if text == "" {
+		current := settings.Template
+		if current == "" {
+			current, _ = p.itemTemplate(&Feed{}, settings)
+		}
+		return response("Template of this channel:\n" + codeBlock(current) + p.previewTemplate(feed, settings))
+	}
+	if text == "--reset" {
+		text = ""
+	}
+	if text != "" {
+		_, err = parseTemplate(text)
+		if err != nil {
+			return response("Error: " + err.Error())
+		}
+	}
+	preview := *settings
+	preview.Template = text
+	rendered := p.previewTemplate(feed, &preview)
+	if strings.HasPrefix(rendered, templateErrorPrefix) {
+		return response(rendered)
+	}
+	err = p.UpdateChannelSettings(args.ChannelId, func(settings *ChannelSettings) error {
+		settings.Template = text
+		return nil
+	})
+	if err != nil {
+		p.client.Log.Error("Error saving channel settings: " + err.Error())
+		return response("Error: unable to save channel settings")
+	}
+	return response("Template of this channel saved." + rendered)
+}
+
+func (p *Plugin) channelTimezone(args *model.CommandArgs, zone string) *model.CommandResponse {
+	if zone == "" {
+		return responseHelp()
+	}
+	_, err := time.LoadLocation(zone)
+	if err != nil {
+		return response("Error: unknown timezone " + zone)
+	}
+	err = p.UpdateChannelSettings(args.ChannelId, func(settings *ChannelSettings) error {
+		settings.Timezone = zone
+		return nil
+	})
+	if err != nil {
+		p.client.Log.Error("Error saving channel settings: " + err.Error())
+		return response("Error: unable to save channel settings")
+	}
+	return response("Dates in this channel are now formatted in " + zone + ".")
+}
+
+const templateErrorPrefix = "Error: "
+
+// previewTemplate renders the latest item of the feed as it would be posted.
+// Failures to render are returned starting with templateErrorPrefix.
+func (p *Plugin) previewTemplate(feed *Feed, settings *ChannelSettings) string {
+	if feed == nil {
+		return ""
+	}
+	page, err := p.fetchPage(feed.URL)
+	if err != nil {
+		return "\n\nNo preview, the feed could not be fetched: " + err.Error()
+	}
+	if len(page.Items) == 0 {
+		return "\n\nNo preview, the feed has no items."
+	}
+	text, location := p.itemTemplate(feed, settings)
+	message, err := renderTemplate(text, location, newTemplateData(feed.URL, page, page.Items[0]))
+	if err != nil {
+		return templateErrorPrefix + err.Error()
+	}
+	return "\n\nPreview of the latest item:\n\n---\n" + message
+}
+
+func codeBlock(text string) string {
+	return "```\n" + text + "\n```"
+}
+
+// FilterCommand handles /feed filter. The pattern of a rule is the rest of
+// the command, so it may contain spaces.
+func (p *Plugin) FilterCommand(args *model.CommandArgs, fields []string) *model.CommandResponse {
+	if len(fields) == 0 {
+		return responseHelp()
+	}
+	feed := p.findChannelFeed(args.ChannelId, fields[0])
+	if feed == nil {
+		return responseNotFound(fields[0])
+	}
+	action := "list"
+	if len(fields) > 1 {
+		action = fields[1]
+	}
+	switch action {
+	case "list":
+		return response(formatFilters(feed))
+	case "test":
+		return p.testFilters(feed)
+	case "clear":
+		return p.updateFilters(feed, func(rules []FilterRule) ([]FilterRule, error) {
+			return nil, nil
+		})
+	case "remove":
+		if len(fields) != 3 {
+			return responseHelp()
+		}
+		n, err := strconv.Atoi(fields[2])
+		if err != nil || n < 1 || n > len(feed.Filters) {
+			return response("Error: no filter rule " + fields[2])
 		}
-		if feed.URL == urlOrIndex || fmt.Sprint(i+1) == urlOrIndex {
-			feeds = slices.Delete(feeds, i, i+1)
-			success, _ := p.SaveFeeds(feeds)
-			if success {
-				userName := p.GetUserName(args.UserId)
-				p.BotPost(args.ChannelId, "**Feed deleted!**\n\n"+feed.URL+" by @"+userName)
-				return response("")
+		return p.updateFilters(feed, func(rules []FilterRule) ([]FilterRule, error) {
+			if n > len(rules) {
+				return nil, fmt.Errorf("no filter rule %d", n)
 			}
-			return response("Error: unable to save feeds")
+			return slices.Delete(rules, n-1, n), nil
+		})
+	case "include", "exclude":
+		params, flags := parseArgs(fields[2:])
+		if len(params) < 2 {
+			return responseHelp()
+		}
+		rule := FilterRule{
+			Exclude: action == "exclude",
+			Field:   params[0],
+			Pattern: strings.Join(params[1:], " "),
+		}
+		if !slices.Contains(FilterFields, rule.Field) {
+			return response("Error: unknown field " + rule.Field + ", use one of " + strings.Join(FilterFields, ", "))
+		}
+		if _, err := compileFilterPattern(rule.Pattern); err != nil {
+			return response("Error: " + err.Error())
+		}
+		for name, value := range flags {
+			if name != "group" {
+				return response("Error: unknown option --" + name)
+			}
+			group, err := strconv.Atoi(value)
+			if err != nil || group < 1 {
+				return response("Error: the group must be a positive number")
+			}
+			rule.Group = group
+		}
+		return p.updateFilters(feed, func(rules []FilterRule) ([]FilterRule, error) {
+			if rule.Group == 0 {
+				rule.Group = nextFilterGroup(rules)
+			}
+			return append(rules, rule), nil
+		})
+	}
+	return responseHelp()
+}
+
+func formatFilters(feed *Feed) string {
+	if len(feed.Filters) == 0 {
+		return feed.URL + " has no filter rules, every new item is posted."
+	}
+	text := "Filter rules of " + feed.URL + ":\n\n"
+	for i, rule := range feed.Filters {
+		text += fmt.Sprintf("%d. `%s`\n", i+1, rule.String())
+	}
+	return text
+}
+
+func (p *Plugin) updateFilters(feed *Feed, update func(rules []FilterRule) ([]FilterRule, error)) *model.CommandResponse {
+	updated, err := p.UpdateFeed(feed.ID, func(stored *Feed) error {
+		rules, err := update(stored.Filters)
+		stored.Filters = rules
+		return err
+	})
+	if errors.Is(err, ErrFeedNotFound) {
+		return responseNotFound(feed.URL)
+	}
+	if err != nil {
+		p.client.Log.Error("Error saving feed: " + err.Error())
+		return response("Error: unable to save feeds")
+	}
+	return response(formatFilters(updated))
+}
+
+// testFilters is a dry run of the filter rules on the current items.
+func (p *Plugin) testFilters(feed *Feed) *model.CommandResponse {
+	filter, err := newItemFilter(feed.Filters)
+	if err != nil {
+		return response("Error: " + err.Error())
+	}
+	page, err := p.fetchPage(feed.URL)
+	if err != nil {
+		return response("Error: the feed could not be fetched: " + err.Error())
+	}
+	passed := 0
+	text := ""
+	for _, item := range page.Items {
+		mark := ":x:"
+		if filter.Accept(item) {
+			mark = ":white_check_mark:"
+			passed++
 		}
+		text += mark + " " + escapeMarkdown(item.Title) + "\n"
 	}
-	return response(urlOrIndex + " is not found in this channel. Please check the URL and try again.")
+	return response(fmt.Sprintf("%d of %d current items of %s pass the filter rules:\n\n", passed, len(page.Items), feed.URL) + text)
 }
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindChannelFeed(t *testing.T) {
+	p, api := setupPlugin(t)
+
+	// Feeds of several channels, as the job sees them. #2 of channel1 has
+	// been deleted, and two channels subscribe to the same URL.
+	feeds := []Feed{
+		{ID: "a1", Number: 1, ChannelID: "channel1", URL: "https://example.com/a"},
+		{ID: "b1", Number: 1, ChannelID: "channel2", URL: "https://example.com/b"},
+		{ID: "a3", Number: 3, ChannelID: "channel1", URL: "https://example.com/shared"},
+		{ID: "b2", Number: 2, ChannelID: "channel2", URL: "https://example.com/shared"},
+		{ID: "c1", Number: 1, ChannelID: "channel3", URL: "https://example.com/c"},
+	}
+	channels := map[string][]string{}
+	for _, feed := range feeds {
+		channels[feed.ChannelID] = append(channels[feed.ChannelID], feed.ID)
+		api.On("KVGet", feedKey(feed.ID)).Return(mustMarshal(t, feed), nil).Maybe()
+	}
+	for channelID, ids := range channels {
+		api.On("KVGet", channelKey(channelID)).Return(mustMarshal(t, ids), nil).Maybe()
+	}
+	api.On("KVGet", channelKey("channel4")).Return(nil, nil).Maybe()
+
+	for _, tc := range []struct {
+		name        string
+		channelID   string
+		urlOrNumber string
+		want        string
+	}{
+		{"number", "channel1", "1", "a1"},
+		{"same number in another channel", "channel2", "1", "b1"},
+		{"number after a deleted feed", "channel1", "3", "a3"},
+		{"hash number", "channel1", "#3", "a3"},
+		{"deleted number is not reused", "channel1", "2", ""},
+		{"position is not a number", "channel2", "3", ""},
+		{"number of another channel", "channel3", "2", ""},
+		{"url", "channel1", "https://example.com/a", "a1"},
+		{"url of another channel", "channel1", "https://example.com/b", ""},
+		{"shared url", "channel1", "https://example.com/shared", "a3"},
+		{"shared url in another channel", "channel2", "https://example.com/shared", "b2"},
+		{"unnormalized url", "channel3", "HTTPS://Example.com/c", "c1"},
+		{"channel without feeds", "channel4", "1", ""},
+		{"zero", "channel1", "0", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			feed := p.findChannelFeed(tc.channelID, tc.urlOrNumber)
+			if tc.want == "" {
+				assert.Nil(t, feed)
+				return
+			}
+			if assert.NotNil(t, feed) {
+				assert.Equal(t, tc.want, feed.ID)
+			}
+		})
+	}
+}
+
+func TestApplyFlagsOverride(t *testing.T) {
+	// Flags come in map order, which changes from run to run.
+	for i := 0; i < 20; i++ {
+		feed := &Feed{Override: true}
+		assert.NoError(t, applyFlags(feed, map[string]string{"username": "default", "icon": "default", "override": "off"}, "UTC"))
+		assert.False(t, feed.Override)
+
+		feed = &Feed{}
+		assert.NoError(t, applyFlags(feed, map[string]string{"username": "News"}, "UTC"))
+		assert.True(t, feed.Override)
+		assert.Equal(t, "News", feed.Username)
+	}
+}
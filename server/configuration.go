@@ -0,0 +1,195 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const DefaultFetchInterval = 20 * time.Minute
+const DefaultFetchTimeout = 30 * time.Second
+const DefaultMaxFailures = 10
+const DefaultFetchWorkers = 8
+const DefaultMaxRequestsPerHost = 2
+const DefaultFetchRunDeadline = 10 * time.Minute
+
+// configuration captures the plugin's external configuration as exposed in the Mattermost server
+// configuration, as well as values computed from the configuration. Any public fields will be
+// deserialized from the Mattermost server configuration in OnConfigurationChange.
+//
+// As plugins are inherently concurrent (hooks being called asynchronously), and the plugin
+// configuration can change at any time, access to the configuration must be synchronized. The
+// strategy used in this plugin is to guard a pointer to the configuration, and clone the entire
+// struct whenever it changes. You may replace this with whatever strategy you choose.
+type configuration struct {
+	// FetchInterval is the default number of minutes between two fetches of
+	// a feed.
+	FetchInterval int
+	// FetchTimeout is the number of seconds a single request may take.
+	FetchTimeout int
+	// FetchWorkers is the number of feeds fetched concurrently, and
+	// MaxRequestsPerHost the number of concurrent requests sent to one host.
+	FetchWorkers       int
+	MaxRequestsPerHost int
+	// FetchRunDeadline is the number of minutes a whole run of the
+	// background job may take.
+	FetchRunDeadline int
+	// MaxItemsPerRun caps the number of items posted per feed and run. Zero
+	// means no limit.
+	MaxItemsPerRun int
+	UserAgent      string
+	// DefaultTemplate is the message template of channels without one.
+	DefaultTemplate string
+	// MaxFailures is the number of consecutive failures after which a feed
+	// is paused.
+	MaxFailures int
+	// AllowedDomains and BlockedDomains are comma or space separated lists
+	// of domains. A domain also matches its subdomains.
+	AllowedDomains string
+	BlockedDomains string
+	// ManagePermission is one of PermissionLevels, the least role needed to
+	// add and change feeds. Empty means anyone.
+	ManagePermission string
+	// OnlyCreatorCanDelete restricts deleting a feed to the user who added
+	// it and to admins.
+	OnlyCreatorCanDelete bool
+}
+
+// Clone shallow copies the configuration. Your implementation may require a deep copy if
+// your configuration has reference types.
+func (c *configuration) Clone() *configuration {
+	var clone = *c
+	return &clone
+}
+
+func (c *configuration) GetFetchInterval() time.Duration {
+	if c.FetchInterval <= 0 {
+		return DefaultFetchInterval
+	}
+	return time.Duration(c.FetchInterval) * time.Minute
+}
+
+func (c *configuration) GetFetchTimeout() time.Duration {
+	if c.FetchTimeout <= 0 {
+		return DefaultFetchTimeout
+	}
+	return time.Duration(c.FetchTimeout) * time.Second
+}
+
+func (c *configuration) GetFetchWorkers() int {
+	if c.FetchWorkers <= 0 {
+		return DefaultFetchWorkers
+	}
+	return c.FetchWorkers
+}
+
+func (c *configuration) GetMaxRequestsPerHost() int {
+	if c.MaxRequestsPerHost <= 0 {
+		return DefaultMaxRequestsPerHost
+	}
+	return c.MaxRequestsPerHost
+}
+
+func (c *configuration) GetFetchRunDeadline() time.Duration {
+	if c.FetchRunDeadline <= 0 {
+		return DefaultFetchRunDeadline
+	}
+	return time.Duration(c.FetchRunDeadline) * time.Minute
+}
+
+func (c *configuration) GetMaxFailures() int {
+	if c.MaxFailures <= 0 {
+		return DefaultMaxFailures
+	}
+	return c.MaxFailures
+}
+
+func splitDomains(domains string) []string {
+	return strings.FieldsFunc(strings.ToLower(domains), func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\n'
+	})
+}
+
+func matchesDomain(host string, domains []string) bool {
+	for _, domain := range domains {
+		domain = strings.TrimPrefix(domain, ".")
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsDomainAllowed reports whether feeds may be fetched from host.
+func (c *configuration) IsDomainAllowed(host string) bool {
+	host = strings.ToLower(host)
+	if matchesDomain(host, splitDomains(c.BlockedDomains)) {
+		return false
+	}
+	allowed := splitDomains(c.AllowedDomains)
+	return len(allowed) == 0 || matchesDomain(host, allowed)
+}
+
+// getConfiguration retrieves the active configuration under lock, making it safe to use
+// concurrently. The active configuration may change underneath the client of this method, but
+// the struct returned by this API call is considered immutable.
+func (p *Plugin) getConfiguration() *configuration {
+	p.configurationLock.RLock()
+	defer p.configurationLock.RUnlock()
+
+	if p.configuration == nil {
+		return &configuration{}
+	}
+
+	return p.configuration
+}
+
+// setConfiguration replaces the active configuration under lock.
+//
+// Do not call setConfiguration while holding the configurationLock, as sync.Mutex is not
+// reentrant. In particular, avoid using the plugin API entirely, as this may in turn trigger a
+// hook back into the plugin. If that hook attempts to acquire this lock, a deadlock may occur.
+//
+// This method panics if setConfiguration is called with the existing configuration. This almost
+// certainly means that the configuration was modified without being cloned and may result in
+// an unsafe access.
+func (p *Plugin) setConfiguration(configuration *configuration) {
+	p.configurationLock.Lock()
+	defer p.configurationLock.Unlock()
+
+	if configuration != nil && p.configuration == configuration {
+		// Ignore assignment if the configuration struct is empty. Go will optimize the
+		// allocation for same to point at the same memory address, breaking the check
+		// above.
+		if reflect.ValueOf(*configuration).NumField() == 0 {
+			return
+		}
+
+		panic("setConfiguration called with the existing configuration")
+	}
+
+	p.configuration = configuration
+}
+
+// OnConfigurationChange is invoked when configuration changes may have been made.
+func (p *Plugin) OnConfigurationChange() error {
+	var configuration = new(configuration)
+
+	// Load the public configuration fields from the Mattermost server configuration.
+	if err := p.API.LoadPluginConfiguration(configuration); err != nil {
+		return errors.Wrap(err, "failed to load plugin configuration")
+	}
+
+	previous := p.getConfiguration()
+	p.setConfiguration(configuration)
+
+	// The hook also runs before OnActivate, when there is nothing to
+	// reschedule yet.
+	if p.client != nil && configuration.GetFetchInterval() != previous.GetFetchInterval() {
+		go p.rescheduleFeeds(configuration.GetFetchInterval())
+	}
+
+	return nil
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
+	"github.com/mmcdole/gofeed"
+)
+
+// DeliveryModes are the ways new items are delivered. The empty mode posts
+// each item immediately.
+var DeliveryModes = []string{"immediate", "hourly", "daily", "weekly"}
+
+// DefaultDigestTime is when daily and weekly digests are posted by default.
+const DefaultDigestTime = "09:00"
+
+const DigestKeyPrefix = "digest_"
+
+// MaxDigestItems is the number of items a digest queue holds. The oldest
+// items are dropped beyond it.
+const MaxDigestItems = 1000
+
+// MaxDigestTitleLength is the number of characters of an item title listed
+// in a digest.
+const MaxDigestTitleLength = 300
+
+// DigestItem is an item queued for the next digest of a feed.
+type DigestItem struct {
+	// ID is the ID of the item, see getItemID.
+	ID        string
+	Title     string
+	Link      string
+	Published int64
+}
+
+func digestKey(feedID string) string {
+	return DigestKeyPrefix + feedID
+}
+
+func (p *Plugin) ScheduleDigestJob() (*cluster.Job, error) {
+	return cluster.Schedule(
+		p.API,
+		"DigestJob",
+		cluster.MakeWaitForRoundedInterval(JobTick),
+		p.PostDigests,
+	)
+}
+
+// QueueDigestItems adds items to the digest queue of a feed, skipping items
+// already queued under the same ID or link.
+func (p *Plugin) QueueDigestItems(feedID string, items []*gofeed.Item) error {
+	return p.client.KV.SetAtomicWithRetries(digestKey(feedID), func(oldValue []byte) (interface{}, error) {
+		queue := []DigestItem{}
+		if len(oldValue) != 0 {
+			err := json.Unmarshal(oldValue, &queue)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, item := range items {
+			id := getItemID(item)
+			if slices.ContainsFunc(queue, func(queued DigestItem) bool {
+				return queued.ID == id || item.Link != "" && queued.Link == item.Link
+			}) {
+				continue
+			}
+			queued := DigestItem{ID: id, Title: item.Title, Link: item.Link}
+			if date := getDate(item); date != nil {
+				queued.Published = date.Unix()
+			}
+			queue = append(queue, queued)
+		}
+		if len(queue) > MaxDigestItems {
+			queue = queue[len(queue)-MaxDigestItems:]
+		}
+		return queue, nil
+	})
+}
+
+// getDigestItems returns the digest queue of a feed.
+func (p *Plugin) getDigestItems(feedID string) ([]DigestItem, error) {
+	queue := []DigestItem{}
+	err := p.client.KV.Get(digestKey(feedID), &queue)
+	return queue, err
+}
+
+// removeDigestItems removes posted items from the digest queue of a feed,
+// keeping the items queued since they were read.
+func (p *Plugin) removeDigestItems(feedID string, posted []DigestItem) error {
+	return p.client.KV.SetAtomicWithRetries(digestKey(feedID), func(oldValue []byte) (interface{}, error) {
+		queue := []DigestItem{}
+		if len(oldValue) != 0 {
+			err := json.Unmarshal(oldValue, &queue)
+			if err != nil {
+				return nil, err
+			}
+		}
+		queue = slices.DeleteFunc(queue, func(queued DigestItem) bool {
+			return slices.Contains(posted, queued)
+		})
+		if len(queue) == 0 {
+			return nil, nil
+		}
+		return queue, nil
+	})
+}
+
+// parseDigestTime parses the HH:MM a digest is posted at.
+func parseDigestTime(s string) (int, int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid time %s, use HH:MM", s)
+	}
+	return t.Hour(), t.Minute(), nil
+}
+
+// parseWeekday parses the name of a day, such as monday or mon.
+func parseWeekday(s string) (time.Weekday, error) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if strings.EqualFold(s, name) || strings.EqualFold(s, name[:3]) {
+			return d, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid day %s", s)
+}
+
+// nextDigest returns when the next digest of the feed is due after now.
+func nextDigest(feed *Feed, now time.Time) int64 {
+	if feed.Delivery == "hourly" {
+		return now.Truncate(time.Hour).Add(time.Hour).Unix()
+	}
+	location, err := time.LoadLocation(feed.DigestTimezone)
+	if err != nil {
+		location = time.UTC
+	}
+	hour, minute, err := parseDigestTime(feed.DigestTime)
+	if err != nil {
+		hour, minute, _ = parseDigestTime(DefaultDigestTime)
+	}
+	local := now.In(location)
+	next := time.Date(local.Year(), local.Month(), local.Day(), hour, minute, 0, 0, location)
+	for !next.After(now) || (feed.Delivery == "weekly" && next.Weekday() != time.Weekday(feed.DigestWeekday)) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next.Unix()
+}
+
+// formatDelivery describes how new items of a feed are delivered.
+func formatDelivery(feed *Feed) string {
+	switch feed.Delivery {
+	case "":
+		return "immediate"
+	case "hourly":
+		return "hourly digest"
+	case "weekly":
+		return fmt.Sprintf("weekly digest on %s at %s %s", time.Weekday(feed.DigestWeekday), feed.DigestTime, feed.DigestTimezone)
+	}
+	return fmt.Sprintf("daily digest at %s %s", feed.DigestTime, feed.DigestTimezone)
+}
+
+// formatDigest renders the first queued items as a markdown list, as many
+// as fit in a post, and returns how many it lists.
+func formatDigest(feed *Feed, items []DigestItem) (string, int) {
+	title := feed.Title
+	if title == "" {
+		title = feed.URL
+	}
+	header := func(n int) string {
+		return fmt.Sprintf("**%s** - %d new items\n\n", escapeMarkdown(truncate(MaxDigestTitleLength, title)), n)
+	}
+	// the header is at its longest with every item
+	left := model.PostMessageMaxRunesV2 - utf8.RuneCountInString(header(len(items)))
+	list := ""
+	n := 0
+	for _, item := range items {
+		itemTitle := escapeMarkdown(truncate(MaxDigestTitleLength, item.Title))
+		if itemTitle == "" {
+			itemTitle = item.Link
+		}
+		line := "- " + itemTitle + "\n"
+		if item.Link != "" {
+			line = "- [" + itemTitle + "](" + item.Link + ")\n"
+		}
+		length := utf8.RuneCountInString(line)
+		if length > left {
+			if n == 0 {
+				// a single line too long for a post is cut, not kept for ever
+				list = cutMarkdown(line, left, "")
+				n = 1
+			}
+			break
+		}
+		list += line
+		left -= length
+		n++
+	}
+	return header(n) + list, n
+}
+
+// PostDigests posts the digests that are due, and schedules the digests of
+// feeds that just switched to a digest mode.
+func (p *Plugin) PostDigests() {
+	now := time.Now()
+	feeds := p.loadDueFeeds(now, func(schedule *Schedule) map[string]int64 {
+		return schedule.Digest
+	}, func(feed *Feed) bool {
+		return feed.Delivery != "" && feed.NextDigest <= now.Unix()
+	})
+	for _, feed := range feeds {
+		// the items of a silenced feed wait for the first digest after it
+		if feed.NextDigest != 0 && !isSilenced(&feed, now) {
+			p.postDigest(&feed)
+		}
+		_, err := p.UpdateFeed(feed.ID, func(stored *Feed) error {
+			stored.NextDigest = nextDigest(stored, now)
+			return nil
+		})
+		if err != nil && !errors.Is(err, ErrFeedNotFound) {
+			p.client.Log.Error("Error saving feed: " + err.Error())
+		}
+	}
+}
+
+// postDigest posts the queued items of a feed, if there are any, in as many
+// posts as they need. Items stay queued for the next digest when a post
+// fails.
+func (p *Plugin) postDigest(feed *Feed) {
+	items, err := p.getDigestItems(feed.ID)
+	if err != nil {
+		p.client.Log.Error("Error loading digest: " + err.Error())
+		return
+	}
+	if len(items) == 0 {
+		return
+	}
+	rootID := p.getThreadRoot(feed)
+	for len(items) > 0 {
+		message, n := formatDigest(feed, items)
+		post := &model.Post{
+			ChannelId: feed.ChannelID,
+			RootId:    rootID,
+			Message:   message,
+		}
+		p.applyOverrides(feed, post)
+		_, err = p.CreateBotPost(post)
+		if err != nil {
+			p.client.Log.Error("Error posting message: " + err.Error())
+			return
+		}
+		err = p.removeDigestItems(feed.ID, items[:n])
+		if err != nil {
+			p.client.Log.Error("Error saving digest: " + err.Error())
+			return
+		}
+		items = items[n:]
+	}
+}
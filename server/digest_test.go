@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mmcdole/gofeed"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueueDigestItemsWithoutLinks(t *testing.T) {
+	p, api := setupPlugin(t)
+
+	queued := mustMarshal(t, []DigestItem{{ID: "1", Title: "First"}})
+	api.On("KVGet", "digest_feed1").Return(queued, nil).Once()
+	var saved []DigestItem
+	api.On("KVSetWithOptions", "digest_feed1", mock.Anything, withOldValue(queued)).Run(func(args mock.Arguments) {
+		require.NoError(t, json.Unmarshal(args.Get(1).([]byte), &saved))
+	}).Return(true, nil).Once()
+
+	require.NoError(t, p.QueueDigestItems("feed1", []*gofeed.Item{
+		{GUID: "1", Title: "First"},
+		{GUID: "2", Title: "Second"},
+		{GUID: "3", Title: "Third"},
+	}))
+	assert.Equal(t, []DigestItem{
+		{ID: "1", Title: "First"},
+		{ID: "2", Title: "Second"},
+		{ID: "3", Title: "Third"},
+	}, saved)
+}
+
+func TestRemoveDigestItemsKeepsNewItems(t *testing.T) {
+	p, api := setupPlugin(t)
+
+	// An item was queued while the digest was being posted.
+	posted := []DigestItem{{ID: "1", Title: "First"}}
+	queued := mustMarshal(t, append(posted, DigestItem{ID: "2", Title: "Second"}))
+	api.On("KVGet", "digest_feed1").Return(queued, nil).Once()
+	var saved []DigestItem
+	api.On("KVSetWithOptions", "digest_feed1", mock.Anything, withOldValue(queued)).Run(func(args mock.Arguments) {
+		require.NoError(t, json.Unmarshal(args.Get(1).([]byte), &saved))
+	}).Return(true, nil).Once()
+
+	require.NoError(t, p.removeDigestItems("feed1", posted))
+	assert.Equal(t, []DigestItem{{ID: "2", Title: "Second"}}, saved)
+}
+
+func TestFormatDigest(t *testing.T) {
+	feed := &Feed{Title: "News", URL: "https://example.com/rss"}
+	items := []DigestItem{
+		{ID: "1", Title: "First", Link: "https://example.com/1"},
+		{ID: "2", Title: "Second"},
+	}
+	text, n := formatDigest(feed, items)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, "**News** - 2 new items\n\n- [First](https://example.com/1)\n- Second\n", text)
+
+	// A weekly digest of a busy feed doesn't fit in one post.
+	items = nil
+	for i := 0; i < 300; i++ {
+		items = append(items, DigestItem{
+			ID:    fmt.Sprint(i),
+			Title: strings.Repeat("title ", 10),
+			Link:  fmt.Sprintf("https://example.com/posts/%d", i),
+		})
+	}
+	text, n = formatDigest(feed, items)
+	assert.Less(t, n, len(items))
+	assert.LessOrEqual(t, utf8.RuneCountInString(text), model.PostMessageMaxRunesV2)
+	assert.True(t, strings.HasPrefix(text, fmt.Sprintf("**News** - %d new items\n\n", n)))
+	assert.True(t, strings.HasSuffix(text, fmt.Sprintf("(https://example.com/posts/%d)\n", n-1)))
+	assert.Equal(t, n+2, strings.Count(text, "\n"))
+
+	rest, m := formatDigest(feed, items[n:])
+	assert.Equal(t, len(items)-n, m)
+	assert.Contains(t, rest, "(https://example.com/posts/299)")
+}
+
+func TestQueueDigestItemsSameLink(t *testing.T) {
+	p, api := setupPlugin(t)
+
+	queued := mustMarshal(t, []DigestItem{{ID: "1", Title: "First", Link: "https://example.com/1"}})
+	api.On("KVGet", "digest_feed1").Return(queued, nil).Once()
+	var saved []DigestItem
+	api.On("KVSetWithOptions", "digest_feed1", mock.Anything, withOldValue(queued)).Run(func(args mock.Arguments) {
+		require.NoError(t, json.Unmarshal(args.Get(1).([]byte), &saved))
+	}).Return(true, nil).Once()
+
+	require.NoError(t, p.QueueDigestItems("feed1", []*gofeed.Item{
+		{GUID: "1-updated", Title: "First", Link: "https://example.com/1"},
+		{GUID: "2", Title: "Second", Link: "https://example.com/2"},
+	}))
+	assert.Equal(t, []DigestItem{
+		{ID: "1", Title: "First", Link: "https://example.com/1"},
+		{ID: "2", Title: "Second", Link: "https://example.com/2"},
+	}, saved)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	neturl "net/url"
+	"slices"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// FeedLinkTypes are the link types of feeds advertised by HTML pages.
+var FeedLinkTypes = map[string]string{
+	"application/rss+xml":   "RSS",
+	"application/atom+xml":  "Atom",
+	"application/feed+json": "JSON Feed",
+}
+
+// feedLink is a feed advertised by an HTML page with
+// <link rel="alternate" type="application/rss+xml" href="...">.
+type feedLink struct {
+	URL   string
+	Title string
+	Type  string
+}
+
+// discoverFeeds returns the feeds advertised by an HTML page served from
+// pageURL, in the order of the page.
+func discoverFeeds(body []byte, pageURL string) []feedLink {
+	base, err := neturl.Parse(pageURL)
+	if err != nil {
+		return nil
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return nil
+	}
+	if href, ok := doc.Find("base[href]").First().Attr("href"); ok {
+		if u, err := base.Parse(strings.TrimSpace(href)); err == nil {
+			base = u
+		}
+	}
+	links := []feedLink{}
+	doc.Find("link[href]").Each(func(_ int, s *goquery.Selection) {
+		rel, _ := s.Attr("rel")
+		if !slices.Contains(strings.Fields(strings.ToLower(rel)), "alternate") {
+			return
+		}
+		mediaType, _ := s.Attr("type")
+		mediaType, _, _ = strings.Cut(strings.ToLower(strings.TrimSpace(mediaType)), ";")
+		name, ok := FeedLinkTypes[strings.TrimSpace(mediaType)]
+		if !ok {
+			return
+		}
+		href, _ := s.Attr("href")
+		u, err := base.Parse(strings.TrimSpace(href))
+		if err != nil || validateURL(u.String()) != nil {
+			return
+		}
+		if slices.ContainsFunc(links, func(link feedLink) bool { return link.URL == u.String() }) {
+			return
+		}
+		title, _ := s.Attr("title")
+		links = append(links, feedLink{URL: u.String(), Title: strings.TrimSpace(title), Type: name})
+	})
+	return links
+}
+
+// formatFeedLinks lists the feeds found on a page, to pick one to add.
+func formatFeedLinks(url string, links []feedLink) string {
+	text := url + " is an HTML page that links to these feeds, add the one you want:\n"
+	for _, link := range links {
+		title := link.Title
+		if title == "" {
+			title = link.URL
+		}
+		text += fmt.Sprintf("\n- %s (%s): `/feed add %s`", escapeMarkdown(title), link.Type, link.URL)
+	}
+	return text
+}
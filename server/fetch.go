@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// MaxBodySize is the largest response read, in bytes.
+const MaxBodySize = 10 << 20
+
+// MaxPageSize is the largest item page read for its og:image, in bytes. The
+// meta tags are in the head, at the start of the page.
+const MaxPageSize = 256 << 10
+
+// MaxPageImages is the number of item pages fetched for their og:image per
+// feed and run.
+const MaxPageImages = 10
+
+// MaxRedirects is the number of redirects followed for one request.
+const MaxRedirects = 5
+
+var ErrDomainNotAllowed = errors.New("domain is not allowed")
+
+// httpStatusError is returned for responses other than 200 and 304.
+type httpStatusError struct {
+	StatusCode int
+	Status     string
+	// RetryAfter is the delay requested by a 429 or 503 response.
+	RetryAfter time.Duration
+}
+
+func (e *httpStatusError) Error() string {
+	return "error: " + e.Status
+}
+
+// parseRetryAfter reads a Retry-After header given in seconds or as a date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil && date.After(now) {
+		return date.Sub(now)
+	}
+	return 0
+}
+
+// fetchResult is the outcome of a conditional GET.
+type fetchResult struct {
+	Body         []byte
+	NotModified  bool
+	ETag         string
+	LastModified string
+	MaxAge       time.Duration
+	// MovedTo is the new URL of a feed reached only through permanent
+	// redirects.
+	MovedTo string
+	// URL is where the body was served from, after redirects.
+	URL string
+}
+
+func isPermanentRedirect(statusCode int) bool {
+	return statusCode == http.StatusMovedPermanently || statusCode == http.StatusPermanentRedirect
+}
+
+// checkRedirect caps and validates the redirects of a request. Hops to
+// another scheme are refused, except upgrades from http to https.
+func checkRedirect(config *configuration, req *http.Request, via []*http.Request) error {
+	if len(via) > MaxRedirects {
+		return fmt.Errorf("stopped after %d redirects", MaxRedirects)
+	}
+	for _, previous := range via {
+		if previous.URL.String() == req.URL.String() {
+			return fmt.Errorf("redirect loop at %s", req.URL)
+		}
+	}
+	from := via[len(via)-1].URL.Scheme
+	if req.URL.Scheme != from && (from != "http" || req.URL.Scheme != "https") {
+		return fmt.Errorf("refused redirect from %s to %s", from, req.URL)
+	}
+	if !config.IsDomainAllowed(req.URL.Hostname()) {
+		return ErrDomainNotAllowed
+	}
+	return nil
+}
+
+// httpGet fetches url, sending the validators of the previous response so
+// an unchanged feed is answered with 304 Not Modified and no body.
+func httpGet(ctx context.Context, client *http.Client, config *configuration, url string, etag string, lastModified string, maxSize int64) (*fetchResult, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !config.IsDomainAllowed(req.URL.Hostname()) {
+		return nil, ErrDomainNotAllowed
+	}
+	if config.UserAgent != "" {
+		req.Header.Set("User-Agent", config.UserAgent)
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		req.Header.Set("If-Modified-Since", lastModified)
+	}
+	// The client is shared between workers, the redirects are tracked per
+	// request.
+	redirected, permanent := false, true
+	redirecting := *client
+	redirecting.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		redirected = true
+		if !isPermanentRedirect(req.Response.StatusCode) {
+			permanent = false
+		}
+		return checkRedirect(config, req, via)
+	}
+	resp, err := redirecting.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	movedTo := ""
+	if redirected && permanent {
+		movedTo = resp.Request.URL.String()
+	}
+	if resp.StatusCode == http.StatusNotModified {
+		return &fetchResult{
+			NotModified:  true,
+			ETag:         etag,
+			LastModified: lastModified,
+			MaxAge:       parseMaxAge(resp.Header),
+			MovedTo:      movedTo,
+		}, nil
+	}
+	if resp.StatusCode != 200 {
+		err := &httpStatusError{StatusCode: resp.StatusCode, Status: resp.Status}
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+			err.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		}
+		return nil, err
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxSize))
+	if err != nil {
+		return nil, err
+	}
+	return &fetchResult{
+		Body:         body,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		MaxAge:       parseMaxAge(resp.Header),
+		MovedTo:      movedTo,
+		URL:          resp.Request.URL.String(),
+	}, nil
+}
+
+// hostLimiter caps the number of concurrent requests to each host.
+type hostLimiter struct {
+	mu    sync.Mutex
+	slots map[string]chan struct{}
+	limit int
+}
+
+func newHostLimiter(limit int) *hostLimiter {
+	return &hostLimiter{
+		slots: map[string]chan struct{}{},
+		limit: limit,
+	}
+}
+
+func (l *hostLimiter) acquire(ctx context.Context, host string) error {
+	l.mu.Lock()
+	slot, ok := l.slots[host]
+	if !ok {
+		slot = make(chan struct{}, l.limit)
+		l.slots[host] = slot
+	}
+	l.mu.Unlock()
+	select {
+	case slot <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *hostLimiter) release(host string) {
+	l.mu.Lock()
+	slot := l.slots[host]
+	l.mu.Unlock()
+	<-slot
+}
+
+// fetchOutcome is the fetched and parsed state of one feed. err is set when
+// the request failed and parseErr when the body could not be parsed.
+type fetchOutcome struct {
+	result   *fetchResult
+	page     *gofeed.Feed
+	hints    feedHints
+	err      error
+	parseErr error
+	// images holds the og:image of the pages of new items that are posted
+	// as cards and have no image of their own, by link.
+	images map[string]string
+}
+
+// fetchAll fetches and parses the feeds with a bounded pool of workers. The
+// outcomes are in the order of feeds.
+func fetchAll(ctx context.Context, config *configuration, client *http.Client, feeds []Feed) []fetchOutcome {
+	limiter := newHostLimiter(config.GetMaxRequestsPerHost())
+	outcomes := make([]fetchOutcome, len(feeds))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range config.GetFetchWorkers() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fp := gofeed.NewParser()
+			for i := range indexes {
+				outcomes[i] = fetchOne(ctx, config, client, limiter, fp, &feeds[i])
+			}
+		}()
+	}
+	for i := range feeds {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return outcomes
+}
+
+func fetchOne(ctx context.Context, config *configuration, client *http.Client, limiter *hostLimiter, fp *gofeed.Parser, feed *Feed) fetchOutcome {
+	u, err := url.Parse(feed.URL)
+	if err != nil {
+		return fetchOutcome{err: err}
+	}
+	err = limiter.acquire(ctx, u.Host)
+	if err != nil {
+		return fetchOutcome{err: err}
+	}
+	// Don't use ParseURL, it doesn't work at https://blogs.oracle.com/oracle4engineer/rss.
+	// It returns a 403 error when fetching with the user agent of gofeed.
+	result, err := httpGet(ctx, client, config, feed.URL, feed.ETag, feed.LastModified, MaxBodySize)
+	// released before the item pages are fetched, they may be on the same host
+	limiter.release(u.Host)
+	if err != nil {
+		return fetchOutcome{err: err}
+	}
+	if result.NotModified {
+		return fetchOutcome{result: result}
+	}
+	page, err := fp.ParseString(string(result.Body))
+	if err != nil {
+		return fetchOutcome{result: result, parseErr: err}
+	}
+	outcome := fetchOutcome{result: result, page: page, hints: parseHints(result.Body, page)}
+	if feed.Format == "card" {
+		outcome.images = fetchPageImages(ctx, config, client, limiter, feed, page)
+	}
+	return outcome
+}
+
+// fetchPageImages fetches the og:image of the pages of the items of a feed
+// about to be posted that have no image of their own, for their cards.
+func fetchPageImages(ctx context.Context, config *configuration, client *http.Client, limiter *hostLimiter, feed *Feed, page *gofeed.Feed) map[string]string {
+	items, _, _ := newItems(feed, page, config, time.Now())
+	images := map[string]string{}
+	for _, item := range items {
+		if len(images) >= MaxPageImages {
+			break
+		}
+		if _, ok := images[item.Link]; ok || item.Link == "" || itemImage(item) != "" {
+			continue
+		}
+		images[item.Link] = fetchOGImage(ctx, config, client, limiter, item.Link)
+	}
+	return images
+}
+
+// AddFetchTimeout bounds the fetch of /feed add, which the user waits for.
+const AddFetchTimeout = 10 * time.Second
+
+// fetchURL fetches a URL right away, for commands.
+func (p *Plugin) fetchURL(url string, timeout time.Duration) (*fetchResult, error) {
+	config := p.getConfiguration()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return httpGet(ctx, p.newHTTPClient(timeout), config, url, "", "", MaxBodySize)
+}
+
+// fetchPage fetches and parses a feed right away, for commands.
+func (p *Plugin) fetchPage(url string) (*gofeed.Feed, error) {
+	result, err := p.fetchURL(url, p.getConfiguration().GetFetchTimeout())
+	if err != nil {
+		return nil, err
+	}
+	return gofeed.NewParser().ParseString(string(result.Body))
+}
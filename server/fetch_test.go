@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPGetRedirects(t *testing.T) {
+	// Each path redirects to the next one with the given status, /feed
+	// serves the feed.
+	redirects := map[string]struct {
+		status int
+		to     string
+	}{
+		"/permanent":      {http.StatusMovedPermanently, "/permanent-next"},
+		"/permanent-next": {http.StatusPermanentRedirect, "/feed"},
+		"/mixed":          {http.StatusMovedPermanently, "/mixed-next"},
+		"/mixed-next":     {http.StatusFound, "/feed"},
+		"/temporary":      {http.StatusTemporaryRedirect, "/feed"},
+		"/loop":           {http.StatusMovedPermanently, "/loop-next"},
+		"/loop-next":      {http.StatusMovedPermanently, "/loop"},
+	}
+	for i := 0; i < MaxRedirects+1; i++ {
+		redirects[fmt.Sprintf("/chain%d", i)] = struct {
+			status int
+			to     string
+		}{http.StatusMovedPermanently, fmt.Sprintf("/chain%d", i+1)}
+	}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if redirect, ok := redirects[r.URL.Path]; ok {
+			http.Redirect(w, r, redirect.to, redirect.status)
+			return
+		}
+		fmt.Fprint(w, "<rss></rss>")
+	})
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	for _, tc := range []struct {
+		name    string
+		path    string
+		movedTo string
+		err     string
+	}{
+		{name: "no redirect", path: "/feed"},
+		{name: "permanent hops", path: "/permanent", movedTo: server.URL + "/feed"},
+		{name: "permanent then temporary hop", path: "/mixed"},
+		{name: "temporary hop", path: "/temporary"},
+		{name: "loop", path: "/loop", err: "redirect loop"},
+		{name: "too many redirects", path: "/chain0", err: "stopped after"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := httpGet(context.Background(), server.Client(), &configuration{}, server.URL+tc.path, "", "", MaxBodySize)
+			if tc.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "<rss></rss>", string(result.Body))
+			assert.Equal(t, tc.movedTo, result.MovedTo)
+			assert.Equal(t, server.URL+"/feed", result.URL)
+		})
+	}
+}
+
+func TestHTTPGetRedirectSchemes(t *testing.T) {
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<rss></rss>")
+	}))
+	defer secure.Close()
+	plain := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, secure.URL+"/feed", http.StatusMovedPermanently)
+	}))
+	defer plain.Close()
+	downgrade := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, plain.URL+"/feed", http.StatusMovedPermanently)
+	}))
+	defer downgrade.Close()
+	client := secure.Client()
+
+	// an upgrade to https is followed
+	result, err := httpGet(context.Background(), client, &configuration{}, plain.URL+"/feed", "", "", MaxBodySize)
+	require.NoError(t, err)
+	assert.Equal(t, secure.URL+"/feed", result.MovedTo)
+
+	// a downgrade to http is refused
+	_, err = httpGet(context.Background(), client, &configuration{}, downgrade.URL+"/feed", "", "", MaxBodySize)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "refused redirect")
+}
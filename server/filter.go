@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/mmcdole/gofeed"
+)
+
+// FilterFields are the item fields filter rules can match.
+var FilterFields = []string{"title", "summary", "author", "categories", "link"}
+
+// FilterRule includes or excludes the items whose field matches a pattern.
+// Rules of the same group must all match; it is enough for one group to
+// match. Rules added without a group each form their own.
+type FilterRule struct {
+	Exclude bool
+	Field   string
+	// Pattern is a case insensitive keyword, or a regular expression when
+	// written as /regex/.
+	Pattern string
+	Group   int
+}
+
+func (r *FilterRule) String() string {
+	mode := "include"
+	if r.Exclude {
+		mode = "exclude"
+	}
+	return fmt.Sprintf("%s %s %s (group %d)", mode, r.Field, r.Pattern, r.Group)
+}
+
+// compileFilterPattern returns a matcher for a filter pattern.
+func compileFilterPattern(pattern string) (func(string) bool, error) {
+	if len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+		re, err := regexp.Compile(pattern[1 : len(pattern)-1])
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	}
+	keyword := strings.ToLower(pattern)
+	return func(s string) bool {
+		return strings.Contains(strings.ToLower(s), keyword)
+	}, nil
+}
+
+func filterFieldValues(item *gofeed.Item, field string) []string {
+	switch field {
+	case "title":
+		return []string{item.Title}
+	case "summary":
+		return []string{htmlToMarkdown(item.Description, ""), htmlToMarkdown(item.Content, "")}
+	case "author":
+		names := []string{}
+		for _, author := range item.Authors {
+			if author != nil {
+				names = append(names, author.Name, author.Email)
+			}
+		}
+		return names
+	case "categories":
+		return item.Categories
+	case "link":
+		return append([]string{item.Link}, item.Links...)
+	}
+	return nil
+}
+
+type compiledRule struct {
+	rule  FilterRule
+	match func(string) bool
+}
+
+func (r *compiledRule) matches(item *gofeed.Item) bool {
+	return slices.ContainsFunc(filterFieldValues(item, r.rule.Field), r.match)
+}
+
+// itemFilter decides which items of a feed are posted.
+type itemFilter struct {
+	includes map[int][]compiledRule
+	excludes map[int][]compiledRule
+}
+
+func newItemFilter(rules []FilterRule) (*itemFilter, error) {
+	f := &itemFilter{
+		includes: map[int][]compiledRule{},
+		excludes: map[int][]compiledRule{},
+	}
+	for _, rule := range rules {
+		match, err := compileFilterPattern(rule.Pattern)
+		if err != nil {
+			return nil, err
+		}
+		groups := f.includes
+		if rule.Exclude {
+			groups = f.excludes
+		}
+		groups[rule.Group] = append(groups[rule.Group], compiledRule{rule: rule, match: match})
+	}
+	return f, nil
+}
+
+func anyGroupMatches(groups map[int][]compiledRule, item *gofeed.Item) bool {
+	for _, rules := range groups {
+		all := true
+		for _, rule := range rules {
+			if !rule.matches(item) {
+				all = false
+				break
+			}
+		}
+		if all {
+			return true
+		}
+	}
+	return false
+}
+
+// Accept reports whether the item passes the rules: it matches an include
+// group, if there are any, and no exclude group.
+func (f *itemFilter) Accept(item *gofeed.Item) bool {
+	if len(f.includes) > 0 && !anyGroupMatches(f.includes, item) {
+		return false
+	}
+	return !anyGroupMatches(f.excludes, item)
+}
+
+// nextFilterGroup returns a group number not used by any rule.
+func nextFilterGroup(rules []FilterRule) int {
+	group := 1
+	for _, rule := range rules {
+		group = max(group, rule.Group+1)
+	}
+	return group
+}
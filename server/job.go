@@ -1,31 +1,51 @@
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"io"
 	"net/http"
+	"slices"
+	"strconv"
 	"time"
 
 	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
 	"github.com/mmcdole/gofeed"
 )
 
-const JobInterval = 20 * time.Minute
+// MaxSeenItems bounds the number of item identities remembered per feed.
+const MaxSeenItems = 1000
+
+// SeenGracePeriod is how far behind Feed.Updated an unseen item may be dated
+// and still be posted. Older items are assumed to be republished with a new
+// identity rather than being new.
+const SeenGracePeriod = 7 * 24 * time.Hour
 
 func (p *Plugin) ScheduleJob() (*cluster.Job, error) {
 	return cluster.Schedule(
 		p.API,
 		"BackgroundJob",
-		cluster.MakeWaitForRoundedInterval(JobInterval),
+		cluster.MakeWaitForRoundedInterval(JobTick),
 		p.FetchFeeds,
 	)
 }
 
 func (p *Plugin) UnscheduleJob() error {
-	if p.backgroundJob == nil {
-		return nil
+	p.jobLock.Lock()
+	defer p.jobLock.Unlock()
+	var err error
+	for _, job := range []**cluster.Job{&p.backgroundJob, &p.digestJob} {
+		if *job == nil {
+			continue
+		}
+		if closeErr := (*job).Close(); closeErr != nil {
+			err = closeErr
+		}
+		*job = nil
 	}
-	return p.backgroundJob.Close()
+	return err
 }
 
 func getDate(item *gofeed.Item) *time.Time {
@@ -36,68 +56,318 @@ func getDate(item *gofeed.Item) *time.Time {
 	return date
 }
 
-func httpGet(url string) ([]byte, error) {
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", url, nil)
+// getItemID returns a stable identity for the item: its GUID, falling back to
+// its link, falling back to a hash of its content.
+func getItemID(item *gofeed.Item) string {
+	if item.GUID != "" {
+		return item.GUID
+	}
+	if item.Link != "" {
+		return item.Link
+	}
+	sum := sha256.Sum256([]byte(item.Title + "\x00" + item.Description + "\x00" + item.Content))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// isNewItem reports whether the item should be posted for the feed.
+// A feed without history only accepts items dated after it was added, so
+// subscribing doesn't flood the channel with the existing backlog.
+func isNewItem(feed *Feed, seen map[string]bool, item *gofeed.Item) bool {
+	if seen[getItemID(item)] {
+		return false
+	}
+	date := getDate(item)
+	if feed.Seen == nil {
+		return date != nil && date.Unix() > feed.Updated
+	}
+	return date == nil || date.Unix() > feed.Updated-int64(SeenGracePeriod/time.Second)
+}
+
+// pruneSeen returns the identities to remember after a fetch: every item
+// currently in the feed, followed by the most recent previously seen ones.
+func pruneSeen(previous []string, items []*gofeed.Item) []string {
+	seen := make([]string, 0, MaxSeenItems)
+	known := map[string]bool{}
+	for _, item := range items {
+		id := getItemID(item)
+		if !known[id] {
+			known[id] = true
+			seen = append(seen, id)
+		}
+	}
+	for _, id := range previous {
+		if len(seen) >= MaxSeenItems {
+			break
+		}
+		if !known[id] {
+			known[id] = true
+			seen = append(seen, id)
+		}
+	}
+	return seen
+}
+
+// FetchFeeds fetches the feeds that are due and posts their new items.
+func (p *Plugin) FetchFeeds() {
+	now := time.Now()
+	feeds := p.loadDueFeeds(now, func(schedule *Schedule) map[string]int64 {
+		return schedule.Fetch
+	}, func(feed *Feed) bool {
+		return isDue(feed, now)
+	})
+	if len(feeds) == 0 {
+		return
+	}
+	config := p.getConfiguration()
+	ctx, cancel := context.WithTimeout(context.Background(), config.GetFetchRunDeadline())
+	defer cancel()
+	outcomes := fetchAll(ctx, config, p.newHTTPClient(config.GetFetchTimeout()), feeds)
+	// Results are handled in the order of the feeds, whatever order the
+	// fetches completed in, so each channel gets its posts deterministically.
+	for i, feed := range feeds {
+		outcome := outcomes[i]
+		switch {
+		case outcome.err != nil && ctx.Err() != nil && errors.Is(outcome.err, ctx.Err()):
+			// The run ran out of time, not the feed: it is still due and
+			// fetched again on the next tick.
+			p.client.Log.Warn(fmt.Sprintf("Fetch run ended before fetching: %s", feed.URL), "error", outcome.err.Error())
+		case outcome.err != nil:
+			p.client.Log.Error(fmt.Sprintf("Error fetching: %s", feed.URL), "error", outcome.err.Error())
+			p.handleFailure(feed, outcome.err, config)
+		case outcome.parseErr != nil:
+			parseErr := outcome.parseErr
+			if outcome.result.MovedTo != "" {
+				// Keep the URL: a feed moved to a page that is not a feed is
+				// more likely gone than moved.
+				parseErr = fmt.Errorf("it permanently redirects to %s, which is not a feed: %w", outcome.result.MovedTo, parseErr)
+			}
+			p.client.Log.Error(fmt.Sprintf("Error parsing: %s", feed.URL), "error", parseErr.Error())
+			p.handleFailure(feed, parseErr, config)
+		case outcome.result.NotModified:
+			p.scheduleFeed(feed, outcome, config)
+			p.moveFeed(feed, outcome.result.MovedTo)
+		default:
+			p.processFeed(feed, outcome, config)
+			p.moveFeed(feed, outcome.result.MovedTo)
+		}
+	}
+}
+
+// moveFeed rewrites the URL of a feed its publisher moved with a permanent
+// redirect, and tells the channel. It does nothing when url is empty. When
+// the channel already subscribes to the new URL, the feed is paused instead
+// so items are not posted twice.
+func (p *Plugin) moveFeed(feed Feed, url string) {
+	if url == "" {
+		return
+	}
+	if existing := p.findChannelFeed(feed.ChannelID, url); existing != nil && existing.ID != feed.ID {
+		p.pauseMovedFeed(feed, url, existing)
+		return
+	}
+	_, err := p.UpdateFeed(feed.ID, func(stored *Feed) error {
+		stored.URL = url
+		return nil
+	})
+	if errors.Is(err, ErrFeedNotFound) {
+		return
+	}
 	if err != nil {
-		return nil, err
+		p.client.Log.Error("Error saving feed: " + err.Error())
+		return
+	}
+	p.BotPost(feed.ChannelID, "**Feed moved!**\n\n"+feed.URL+" permanently redirects to "+url+", the feed now uses the new URL.")
+}
+
+// pauseMovedFeed pauses a feed that moved to the URL of another feed of the
+// channel, and tells the channel.
+func (p *Plugin) pauseMovedFeed(feed Feed, url string, existing *Feed) {
+	_, err := p.UpdateFeed(feed.ID, func(stored *Feed) error {
+		stored.Paused = true
+		stored.PausedReason = fmt.Sprintf("it moved to %s, which this channel already subscribes to as #%d", url, existing.Number)
+		return nil
+	})
+	if errors.Is(err, ErrFeedNotFound) {
+		return
 	}
-	resp, err := client.Do(req)
 	if err != nil {
-		return nil, err
+		p.client.Log.Error("Error saving feed: " + err.Error())
+		return
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("error: %s", resp.Status)
+	p.BotPost(feed.ChannelID, fmt.Sprintf("**Feed paused!**\n\n%s permanently redirects to %s, which this channel already subscribes to as #%d. "+
+		"It is no longer fetched so items are not posted twice, delete it with `/feed del %d`.", feed.URL, url, existing.Number, feed.Number))
+}
+
+// scheduleFeed sets when a feed that had nothing to post is due again.
+func (p *Plugin) scheduleFeed(feed Feed, outcome fetchOutcome, config *configuration) {
+	_, err := p.UpdateFeed(feed.ID, func(stored *Feed) error {
+		stored.Failures = 0
+		stored.LastSuccessAt = time.Now().Unix()
+		stored.NextFetch = nextFetch(stored, time.Now(), fetchInterval(stored, config.GetFetchInterval(), outcome.result.MaxAge))
+		return nil
+	})
+	if err != nil && !errors.Is(err, ErrFeedNotFound) {
+		p.client.Log.Error("Error saving feed: " + err.Error())
+	}
+}
+
+// handleFailure records a failed fetch and backs off exponentially. The feed
+// is paused when it is gone for good or has failed too many times in a row,
+// and the channel is told about it.
+func (p *Plugin) handleFailure(feed Feed, fetchErr error, config *configuration) {
+	var statusErr *httpStatusError
+	retryAfter := time.Duration(0)
+	gone := false
+	if errors.As(fetchErr, &statusErr) {
+		retryAfter = statusErr.RetryAfter
+		gone = statusErr.StatusCode == http.StatusGone
+	}
+	stored, err := p.UpdateFeed(feed.ID, func(stored *Feed) error {
+		now := time.Now()
+		stored.Failures++
+		stored.LastError = describeFetchError(fetchErr, config.GetFetchTimeout())
+		stored.LastErrorAt = now.Unix()
+		switch {
+		case gone:
+			stored.Paused = true
+			stored.PausedReason = "the server says the feed is gone for good (" + statusErr.Status + ")"
+		case stored.Failures >= config.GetMaxFailures():
+			stored.Paused = true
+			stored.PausedReason = fmt.Sprintf("it failed %d times in a row, last with: %s", stored.Failures, stored.LastError)
+		}
+		interval := fetchInterval(stored, config.GetFetchInterval(), 0)
+		stored.NextFetch = now.Add(backoff(interval, stored.Failures, retryAfter)).Unix()
+		return nil
+	})
+	if errors.Is(err, ErrFeedNotFound) {
+		return
 	}
-	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, err
+		p.client.Log.Error("Error saving feed: " + err.Error())
+		return
+	}
+	if stored.Paused && !feed.Paused {
+		p.BotPost(feed.ChannelID, "**Feed paused!**\n\n"+feed.URL+" is no longer fetched because "+stored.PausedReason+
+			".\n\nOnce the feed works again, resume it with `/feed resume "+strconv.Itoa(feed.Number)+"`.")
 	}
-	return body, nil
 }
 
-func (p *Plugin) FetchFeeds() {
-	feeds := p.LoadFeeds()
-	fp := gofeed.NewParser()
-	for i, feed := range feeds {
-		// Don't use ParseURL, it doesn't work at https://blogs.oracle.com/oracle4engineer/rss.
-		// It returns a 403 error when fetching with the user agent of gofeed.
-		body, err := httpGet(feed.URL)
-		if err != nil {
-			p.client.Log.Error(fmt.Sprintf("Error fetching: %s", feed.URL))
-			continue
+// newItems returns the items of page to post for the feed: the new items
+// its filters accept, at most MaxItemsPerRun when it is set. It also returns
+// the high-water mark of the feed, the date of its latest new item up to
+// now. The filters are ignored when they are invalid, and err is set.
+func newItems(feed *Feed, page *gofeed.Feed, config *configuration, now time.Time) ([]*gofeed.Item, int64, error) {
+	seen := make(map[string]bool, len(feed.Seen))
+	for _, id := range feed.Seen {
+		seen[id] = true
+	}
+	filter, err := newItemFilter(feed.Filters)
+	if err != nil {
+		filter, _ = newItemFilter(nil)
+	}
+	items := []*gofeed.Item{}
+	for _, item := range page.Items {
+		if isNewItem(feed, seen, item) {
+			items = append(items, item)
+			// a page may list the same item twice
+			seen[getItemID(item)] = true
 		}
-		page, err := fp.ParseString(string(body))
-		if err != nil {
-			p.client.Log.Error(fmt.Sprintf("Error parsing: %s", feed.URL))
+	}
+	// future-dated items must not hold back the high-water mark
+	latest := feed.Updated
+	for _, item := range items {
+		date := getDate(item)
+		if date == nil {
 			continue
 		}
-		items := page.Items
-		// filter dates exists and newer than feed.Updated
-		itemsValid := []*gofeed.Item{}
-		for _, item := range items {
-			date := getDate(item)
-			if date == nil || date.Unix() <= feed.Updated {
-				continue
-			}
-			itemsValid = append(itemsValid, item)
+		if u := date.Unix(); u > latest && u <= now.Unix() {
+			latest = u
 		}
-		items = itemsValid
-		for _, item := range items {
-			p.BotPost(feed.ChannelID, fmt.Sprintf("%s | %s\n%s", item.Title, page.Title, item.Link))
+	}
+	// filtered out items are seen all the same, so they are never posted,
+	// and so are the items skipped beyond MaxItemsPerRun
+	items = slices.DeleteFunc(items, func(item *gofeed.Item) bool {
+		return !filter.Accept(item)
+	})
+	if config.MaxItemsPerRun > 0 && len(items) > config.MaxItemsPerRun {
+		items = items[:config.MaxItemsPerRun]
+	}
+	return items, min(latest, now.Unix()), err
+}
+
+// processFeed posts the new items of a fetched feed and saves its state.
+func (p *Plugin) processFeed(feed Feed, outcome fetchOutcome, config *configuration) {
+	result, page := outcome.result, outcome.page
+	var items []*gofeed.Item
+	silenced := false
+	// The new items are picked and the fetch state is written back on the
+	// latest stored copy, so commands run during the fetch, such as pause
+	// or resume --skip-backlog, are honored and not reverted. Items of a
+	// feed deleted in the meantime are not posted.
+	stored, err := p.UpdateFeed(feed.ID, func(stored *Feed) error {
+		now := time.Now()
+		items = nil
+		stored.Failures = 0
+		stored.LastSuccessAt = now.Unix()
+		stored.Title = page.Title
+		stored.SiteURL = page.Link
+		stored.ImageURL = ""
+		if page.Image != nil {
+			stored.ImageURL = page.Image.URL
 		}
-		latest := feed.Updated
-		for _, item := range items {
-			u := getDate(item).Unix()
-			if u > latest {
-				latest = u
-			}
+		stored.PublisherInterval = int64(outcome.hints.Interval / time.Second)
+		stored.SkipHours = outcome.hints.SkipHours
+		stored.SkipDays = outcome.hints.SkipDays
+		stored.NextFetch = nextFetch(stored, now, fetchInterval(stored, config.GetFetchInterval(), result.MaxAge))
+		// The items of a feed silenced meanwhile wait until it is resumed:
+		// neither they nor the validators of the response are recorded.
+		silenced = isSilenced(stored, now)
+		if silenced {
+			return nil
+		}
+		picked, latest, err := newItems(stored, page, config, now)
+		if err != nil {
+			p.client.Log.Error("Error in filters of feed: "+stored.URL, "error", err.Error())
+		}
+		items = picked
+		if len(items) > 0 {
+			stored.LastItemAt = now.Unix()
+		}
+		stored.Updated = latest
+		stored.Seen = pruneSeen(stored.Seen, page.Items)
+		stored.ETag = result.ETag
+		stored.LastModified = result.LastModified
+		return nil
+	})
+	if errors.Is(err, ErrFeedNotFound) {
+		return
+	}
+	if err != nil {
+		p.client.Log.Error("Error saving feed: " + err.Error())
+		return
+	}
+	if silenced || len(items) == 0 {
+		return
+	}
+	if stored.Delivery != "" {
+		err = p.QueueDigestItems(stored.ID, items)
+		if err != nil {
+			p.client.Log.Error("Error queuing digest items: " + err.Error())
 		}
-		feeds[i].Updated = latest
+		return
 	}
-	success, _ := p.SaveFeeds(feeds)
-	if !success {
-		p.client.Log.Error("Error saving feeds")
+	settings, err := p.GetChannelSettings(stored.ChannelID)
+	if err != nil {
+		p.client.Log.Error("Error loading channel settings: " + err.Error())
+	}
+	rootID := p.getThreadRoot(stored)
+	for _, item := range items {
+		post := p.newItemPost(stored, settings, page, item, outcome.images[item.Link])
+		post.RootId = rootID
+		p.applyOverrides(stored, post)
+		_, err = p.CreateBotPost(post)
+		if err != nil {
+			p.client.Log.Error("Error posting message: " + err.Error())
+		}
 	}
 }
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mmcdole/gofeed"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessFeedUsesStoredFeed(t *testing.T) {
+	now := time.Now()
+	published := now.Add(-time.Hour)
+	page := &gofeed.Feed{Title: "News", Items: []*gofeed.Item{
+		{GUID: "old", Title: "Old", PublishedParsed: &published},
+		{GUID: "new", Title: "New", PublishedParsed: &published},
+	}}
+	outcome := fetchOutcome{result: &fetchResult{ETag: `"2"`}, page: page}
+	// the snapshot the run started with has only seen the old item
+	snapshot := Feed{ID: "feed1", ChannelID: "channel1", URL: "https://example.com/rss", Seen: []string{"old"}, ETag: `"1"`, Updated: now.Add(-2 * time.Hour).Unix()}
+
+	t.Run("paused during the run", func(t *testing.T) {
+		p, api := setupPlugin(t)
+		stored := snapshot
+		stored.Paused = true
+		data := mustMarshal(t, &stored)
+		api.On("KVGet", "feed_feed1").Return(data, nil).Once()
+		var saved Feed
+		api.On("KVSetWithOptions", "feed_feed1", mock.Anything, withOldValue(data)).Run(func(args mock.Arguments) {
+			require.NoError(t, json.Unmarshal(args.Get(1).([]byte), &saved))
+		}).Return(true, nil).Once()
+
+		p.processFeed(snapshot, outcome, &configuration{})
+		api.AssertNotCalled(t, "CreatePost", mock.Anything)
+		assert.Equal(t, []string{"old"}, saved.Seen)
+		assert.Equal(t, `"1"`, saved.ETag)
+	})
+
+	t.Run("backlog skipped during the run", func(t *testing.T) {
+		p, api := setupPlugin(t)
+		stored := snapshot
+		stored.Seen = []string{"old", "new"}
+		stored.Updated = now.Unix()
+		data := mustMarshal(t, &stored)
+		api.On("KVGet", "feed_feed1").Return(data, nil).Once()
+		var saved Feed
+		api.On("KVSetWithOptions", "feed_feed1", mock.Anything, withOldValue(data)).Run(func(args mock.Arguments) {
+			require.NoError(t, json.Unmarshal(args.Get(1).([]byte), &saved))
+		}).Return(true, nil).Once()
+		expectScheduleUpdates(api, 1)
+
+		p.processFeed(snapshot, outcome, &configuration{})
+		api.AssertNotCalled(t, "CreatePost", mock.Anything)
+		assert.Equal(t, []string{"old", "new"}, saved.Seen)
+		assert.Equal(t, stored.Updated, saved.Updated)
+		assert.Equal(t, `"2"`, saved.ETag)
+	})
+}
+
+func TestMoveFeedAlreadySubscribed(t *testing.T) {
+	p, api := setupPlugin(t)
+
+	// The channel subscribes to both the old and the new URL of a feed.
+	old := Feed{ID: "feed1", Number: 1, ChannelID: "channel1", URL: "https://example.com/rss"}
+	moved := Feed{ID: "feed2", Number: 2, ChannelID: "channel1", URL: "https://example.com/feed.xml"}
+	oldData := mustMarshal(t, &old)
+	api.On("KVGet", "channel_channel1").Return(mustMarshal(t, []string{"feed1", "feed2"}), nil).Once()
+	api.On("KVGet", "feed_feed1").Return(oldData, nil).Times(2)
+	api.On("KVGet", "feed_feed2").Return(mustMarshal(t, &moved), nil).Once()
+	var saved Feed
+	api.On("KVSetWithOptions", "feed_feed1", mock.Anything, withOldValue(oldData)).Run(func(args mock.Arguments) {
+		require.NoError(t, json.Unmarshal(args.Get(1).([]byte), &saved))
+	}).Return(true, nil).Once()
+	expectScheduleUpdates(api, 1)
+	api.On("CreatePost", mock.Anything).Return(&model.Post{}, nil).Once()
+
+	p.moveFeed(old, moved.URL)
+	assert.Equal(t, old.URL, saved.URL)
+	assert.True(t, saved.Paused)
+	assert.Contains(t, saved.PausedReason, "#2")
+}
+
+func TestGetItemID(t *testing.T) {
+	assert.Equal(t, "guid", getItemID(&gofeed.Item{GUID: "guid", Link: "https://example.com/1"}))
+	assert.Equal(t, "https://example.com/1", getItemID(&gofeed.Item{Link: "https://example.com/1", Title: "One"}))
+	hashed := getItemID(&gofeed.Item{Title: "One", Description: "Text"})
+	assert.True(t, strings.HasPrefix(hashed, "sha256:"))
+	assert.Equal(t, hashed, getItemID(&gofeed.Item{Title: "One", Description: "Text"}))
+	assert.NotEqual(t, hashed, getItemID(&gofeed.Item{Title: "One", Description: "Other"}))
+}
+
+func TestIsNewItem(t *testing.T) {
+	added := time.Date(2026, 5, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		date := added.Add(d)
+		return &date
+	}
+	fresh := &Feed{Updated: added.Unix()}
+	known := &Feed{Updated: added.Unix(), Seen: []string{"seen"}}
+	for _, tc := range []struct {
+		name  string
+		feed  *Feed
+		item  *gofeed.Item
+		isNew bool
+	}{
+		{"no history, dated after it was added", fresh, &gofeed.Item{GUID: "a", PublishedParsed: at(time.Minute)}, true},
+		{"no history, dated before it was added", fresh, &gofeed.Item{GUID: "a", PublishedParsed: at(-time.Minute)}, false},
+		{"no history, undated", fresh, &gofeed.Item{GUID: "a"}, false},
+		{"no history, updated date", fresh, &gofeed.Item{GUID: "a", UpdatedParsed: at(time.Minute)}, true},
+		{"seen", known, &gofeed.Item{GUID: "seen", PublishedParsed: at(time.Hour)}, false},
+		{"unseen and undated", known, &gofeed.Item{GUID: "a"}, true},
+		{"unseen and newer", known, &gofeed.Item{GUID: "a", PublishedParsed: at(time.Hour)}, true},
+		{"unseen within the grace period", known, &gofeed.Item{GUID: "a", PublishedParsed: at(-SeenGracePeriod + time.Hour)}, true},
+		{"unseen before the grace period", known, &gofeed.Item{GUID: "a", PublishedParsed: at(-SeenGracePeriod - time.Hour)}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			seen := map[string]bool{}
+			for _, id := range tc.feed.Seen {
+				seen[id] = true
+			}
+			assert.Equal(t, tc.isNew, isNewItem(tc.feed, seen, tc.item))
+		})
+	}
+}
+
+func TestNewItems(t *testing.T) {
+	now := time.Now()
+	at := func(d time.Duration) *time.Time {
+		date := now.Add(d)
+		return &date
+	}
+	feed := &Feed{Updated: now.Add(-time.Hour).Unix(), Seen: []string{"old"}}
+	page := &gofeed.Feed{Items: []*gofeed.Item{
+		{GUID: "old", PublishedParsed: at(-2 * time.Hour)},
+		{GUID: "a", PublishedParsed: at(-30 * time.Minute)},
+		{GUID: "future", PublishedParsed: at(24 * time.Hour)},
+		{GUID: "a", PublishedParsed: at(-30 * time.Minute)},
+		{Link: "https://example.com/b"},
+	}}
+
+	items, latest, err := newItems(feed, page, &configuration{}, now)
+	require.NoError(t, err)
+	ids := []string{}
+	for _, item := range items {
+		ids = append(ids, getItemID(item))
+	}
+	assert.Equal(t, []string{"a", "future", "https://example.com/b"}, ids)
+	// the future-dated item doesn't move the high-water mark past now
+	assert.Equal(t, at(-30*time.Minute).Unix(), latest)
+
+	items, _, err = newItems(feed, page, &configuration{MaxItemsPerRun: 1}, now)
+	require.NoError(t, err)
+	assert.Len(t, items, 1)
+}
+
+func TestPruneSeen(t *testing.T) {
+	items := []*gofeed.Item{{GUID: "c"}, {GUID: "b"}, {GUID: "c"}}
+	assert.Equal(t, []string{"c", "b", "a"}, pruneSeen([]string{"b", "a"}, items))
+
+	previous := []string{}
+	for i := 0; i < MaxSeenItems; i++ {
+		previous = append(previous, fmt.Sprint("old", i))
+	}
+	seen := pruneSeen(previous, items)
+	assert.Len(t, seen, MaxSeenItems)
+	assert.Equal(t, []string{"c", "b", "old0"}, seen[:3])
+	assert.Equal(t, fmt.Sprint("old", MaxSeenItems-3), seen[MaxSeenItems-1])
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ScheduleKey holds the Schedule of every feed.
+const ScheduleKey = "schedule"
+
+// ScheduleRebuildInterval is how often the schedule is rebuilt from the
+// feeds, to recover from writes interrupted between a feed and the schedule.
+const ScheduleRebuildInterval = time.Hour
+
+// Schedule tells when each feed is next due, so the jobs find the feeds to
+// fetch and the digests to post without loading every feed on each tick.
+type Schedule struct {
+	// Fetch holds the feeds that are not paused, Digest those delivered in
+	// digests, by ID.
+	Fetch  map[string]int64
+	Digest map[string]int64
+}
+
+// fetchDue returns when a feed is next fetched, false if it is paused.
+func fetchDue(feed *Feed) (int64, bool) {
+	if feed.Paused {
+		return 0, false
+	}
+	return max(feed.NextFetch, feed.SnoozeUntil), true
+}
+
+// digestDue returns when the next digest of a feed is posted, false if it
+// posts items immediately.
+func digestDue(feed *Feed) (int64, bool) {
+	if feed.Delivery == "" {
+		return 0, false
+	}
+	return feed.NextDigest, true
+}
+
+// scheduleChanged reports whether a change of a feed moved it in the
+// schedule.
+func scheduleChanged(before *Feed, after *Feed) bool {
+	fetchBefore, fetchedBefore := fetchDue(before)
+	fetchAfter, fetchedAfter := fetchDue(after)
+	digestBefore, digestedBefore := digestDue(before)
+	digestAfter, digestedAfter := digestDue(after)
+	return fetchBefore != fetchAfter || fetchedBefore != fetchedAfter ||
+		digestBefore != digestAfter || digestedBefore != digestedAfter
+}
+
+// setDue sets the entries of a feed in the schedule, or removes them when
+// feed is nil.
+func (s *Schedule) setDue(id string, feed *Feed) {
+	delete(s.Fetch, id)
+	delete(s.Digest, id)
+	if feed == nil {
+		return
+	}
+	if due, ok := fetchDue(feed); ok {
+		s.Fetch[id] = due
+	}
+	if due, ok := digestDue(feed); ok {
+		s.Digest[id] = due
+	}
+}
+
+func newSchedule() *Schedule {
+	return &Schedule{Fetch: map[string]int64{}, Digest: map[string]int64{}}
+}
+
+// updateSchedule applies update to the schedule with compare-and-set.
+func (p *Plugin) updateSchedule(update func(schedule *Schedule)) error {
+	return p.client.KV.SetAtomicWithRetries(ScheduleKey, func(oldValue []byte) (interface{}, error) {
+		schedule := newSchedule()
+		if len(oldValue) != 0 {
+			err := json.Unmarshal(oldValue, schedule)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if schedule.Fetch == nil {
+			schedule.Fetch = map[string]int64{}
+		}
+		if schedule.Digest == nil {
+			schedule.Digest = map[string]int64{}
+		}
+		update(schedule)
+		return schedule, nil
+	})
+}
+
+// setFeedDue updates the entries of a feed in the schedule, removing them
+// when feed is nil.
+func (p *Plugin) setFeedDue(id string, feed *Feed) {
+	err := p.updateSchedule(func(schedule *Schedule) {
+		schedule.setDue(id, feed)
+	})
+	if err != nil {
+		p.client.Log.Error("Error saving schedule: " + err.Error())
+	}
+}
+
+// rebuildSchedule rebuilds the schedule from every feed. Entries changed
+// while the feeds were loaded are merged in, keeping the earliest due time:
+// the jobs check the feed itself before acting on it.
+func (p *Plugin) rebuildSchedule() (*Schedule, error) {
+	rebuilt := newSchedule()
+	known := map[string]bool{}
+	for _, feed := range p.LoadFeeds() {
+		rebuilt.setDue(feed.ID, &feed)
+		known[feed.ID] = true
+	}
+	err := p.updateSchedule(func(schedule *Schedule) {
+		for _, entries := range []struct{ stored, rebuilt map[string]int64 }{
+			{schedule.Fetch, rebuilt.Fetch},
+			{schedule.Digest, rebuilt.Digest},
+		} {
+			for id, due := range entries.stored {
+				rebuiltDue, ok := entries.rebuilt[id]
+				switch {
+				case ok:
+					entries.rebuilt[id] = min(due, rebuiltDue)
+				case !known[id]:
+					// added meanwhile, or deleted: the jobs drop deleted feeds
+					entries.rebuilt[id] = due
+				}
+			}
+		}
+		*schedule = *rebuilt
+	})
+	return rebuilt, err
+}
+
+// loadSchedule returns the schedule, rebuilding it on the first run of the
+// jobs on this node and every ScheduleRebuildInterval.
+func (p *Plugin) loadSchedule() (*Schedule, error) {
+	p.scheduleLock.Lock()
+	defer p.scheduleLock.Unlock()
+	if time.Since(p.scheduleRebuiltAt) > ScheduleRebuildInterval {
+		schedule, err := p.rebuildSchedule()
+		if err == nil {
+			p.scheduleRebuiltAt = time.Now()
+		}
+		return schedule, err
+	}
+	schedule := newSchedule()
+	err := p.client.KV.Get(ScheduleKey, schedule)
+	return schedule, err
+}
+
+// loadDueFeeds returns the feeds the schedule says are due in entries, that
+// are due according to isDue, oldest first.
+func (p *Plugin) loadDueFeeds(now time.Time, entries func(schedule *Schedule) map[string]int64, isDue func(feed *Feed) bool) []Feed {
+	schedule, err := p.loadSchedule()
+	if err != nil {
+		p.client.Log.Error("Error loading schedule: " + err.Error())
+		return nil
+	}
+	feeds := []Feed{}
+	for id, due := range entries(schedule) {
+		if due > now.Unix() {
+			continue
+		}
+		feed, err := p.GetFeed(id)
+		if err != nil {
+			p.client.Log.Error("Error loading feed: " + err.Error())
+			continue
+		}
+		if feed == nil {
+			p.setFeedDue(id, nil)
+			continue
+		}
+		if !isDue(feed) {
+			// a write was interrupted between the feed and the schedule
+			p.setFeedDue(id, feed)
+			continue
+		}
+		feeds = append(feeds, *feed)
+	}
+	slices.SortFunc(feeds, func(a, b Feed) int {
+		return cmp.Or(cmp.Compare(a.CreatedAt, b.CreatedAt), strings.Compare(a.ID, b.ID))
+	})
+	return feeds
+}
@@ -1,16 +1,312 @@
 package main
 
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
+)
+
+// KVKey is the legacy key holding every subscription in a single blob.
 const KVKey = "dev.manybugs.feed"
 
-func (p *Plugin) SaveFeeds(feeds []Feed) (bool, error) {
-	return p.client.KV.Set(KVKey, feeds)
+const FeedKeyPrefix = "feed_"
+const ChannelKeyPrefix = "channel_"
+const ChannelSettingsKeyPrefix = "settings_"
+
+const kvListPageSize = 1000
+
+var ErrFeedNotFound = errors.New("feed not found")
+
+func feedKey(id string) string {
+	return FeedKeyPrefix + id
 }
 
-func (p *Plugin) LoadFeeds() []Feed {
+func channelKey(channelID string) string {
+	return ChannelKeyPrefix + channelID
+}
+
+func channelSettingsKey(channelID string) string {
+	return ChannelSettingsKeyPrefix + channelID
+}
+
+func (p *Plugin) GetChannelSettings(channelID string) (*ChannelSettings, error) {
+	settings := &ChannelSettings{}
+	err := p.client.KV.Get(channelSettingsKey(channelID), settings)
+	return settings, err
+}
+
+// UpdateChannelSettings applies update to the settings of a channel with
+// compare-and-set.
+func (p *Plugin) UpdateChannelSettings(channelID string, update func(settings *ChannelSettings) error) error {
+	return p.client.KV.SetAtomicWithRetries(channelSettingsKey(channelID), func(oldValue []byte) (interface{}, error) {
+		settings := &ChannelSettings{}
+		if len(oldValue) != 0 {
+			err := json.Unmarshal(oldValue, settings)
+			if err != nil {
+				return nil, err
+			}
+		}
+		err := update(settings)
+		if err != nil {
+			return nil, err
+		}
+		return settings, nil
+	})
+}
+
+func (p *Plugin) GetFeed(id string) (*Feed, error) {
+	var feed *Feed
+	err := p.client.KV.Get(feedKey(id), &feed)
+	if err != nil {
+		return nil, err
+	}
+	return feed, nil
+}
+
+func (p *Plugin) SaveFeed(feed *Feed) error {
+	success, err := p.client.KV.Set(feedKey(feed.ID), feed)
+	if err != nil {
+		return err
+	}
+	if !success {
+		return fmt.Errorf("unable to save feed %s", feed.ID)
+	}
+	return nil
+}
+
+// UpdateFeed applies update to the stored feed with compare-and-set, reloading
+// and retrying when someone else changed it in the meantime, and keeps the
+// schedule up to date. It returns ErrFeedNotFound if the feed has been
+// deleted.
+func (p *Plugin) UpdateFeed(id string, update func(feed *Feed) error) (*Feed, error) {
+	var before, feed *Feed
+	err := p.client.KV.SetAtomicWithRetries(feedKey(id), func(oldValue []byte) (interface{}, error) {
+		if len(oldValue) == 0 {
+			return nil, ErrFeedNotFound
+		}
+		feed = &Feed{}
+		err := json.Unmarshal(oldValue, feed)
+		if err != nil {
+			return nil, err
+		}
+		before = &Feed{}
+		*before = *feed
+		err = update(feed)
+		if err != nil {
+			return nil, err
+		}
+		return feed, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if scheduleChanged(before, feed) {
+		p.setFeedDue(id, feed)
+	}
+	return feed, nil
+}
+
+// CreateFeed assigns an ID, unless it has one, and a number to the feed,
+// stores it and adds it to the index of its channel.
+func (p *Plugin) CreateFeed(feed *Feed) error {
+	if feed.ID == "" {
+		feed.ID = model.NewId()
+	}
+	if feed.CreatedAt == 0 {
+		feed.CreatedAt = time.Now().Unix()
+	}
+	number, err := p.nextFeedNumber(feed.ChannelID)
+	if err != nil {
+		return err
+	}
+	feed.Number = number
+	err = p.SaveFeed(feed)
+	if err != nil {
+		return err
+	}
+	p.setFeedDue(feed.ID, feed)
+	return p.indexFeed(feed)
+}
+
+// indexFeed adds a stored feed to the index of its channel, if it isn't
+// there yet.
+func (p *Plugin) indexFeed(feed *Feed) error {
+	return p.updateChannelFeedIDs(feed.ChannelID, func(ids []string) []string {
+		if slices.Contains(ids, feed.ID) {
+			return ids
+		}
+		return append(ids, feed.ID)
+	})
+}
+
+// nextFeedNumber reserves the number of a new feed of a channel.
+func (p *Plugin) nextFeedNumber(channelID string) (int, error) {
+	number := 0
+	err := p.UpdateChannelSettings(channelID, func(settings *ChannelSettings) error {
+		settings.LastFeedNumber++
+		number = settings.LastFeedNumber
+		return nil
+	})
+	return number, err
+}
+
+func (p *Plugin) DeleteFeed(feed *Feed) error {
+	err := p.updateChannelFeedIDs(feed.ChannelID, func(ids []string) []string {
+		return slices.DeleteFunc(ids, func(id string) bool { return id == feed.ID })
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range []string{digestKey(feed.ID), threadKey(feed.ID)} {
+		err = p.client.KV.Delete(key)
+		if err != nil {
+			return err
+		}
+	}
+	err = p.client.KV.Delete(feedKey(feed.ID))
+	if err != nil {
+		return err
+	}
+	p.setFeedDue(feed.ID, nil)
+	return nil
+}
+
+func (p *Plugin) getChannelFeedIDs(channelID string) ([]string, error) {
+	ids := []string{}
+	err := p.client.KV.Get(channelKey(channelID), &ids)
+	return ids, err
+}
+
+// updateChannelFeedIDs applies update to the index of a channel with
+// compare-and-set, so concurrent commands on any node don't drop each other's
+// changes.
+func (p *Plugin) updateChannelFeedIDs(channelID string, update func(ids []string) []string) error {
+	return p.client.KV.SetAtomicWithRetries(channelKey(channelID), func(oldValue []byte) (interface{}, error) {
+		ids := []string{}
+		if len(oldValue) != 0 {
+			err := json.Unmarshal(oldValue, &ids)
+			if err != nil {
+				return nil, err
+			}
+		}
+		ids = update(ids)
+		if len(ids) == 0 {
+			return nil, nil
+		}
+		return ids, nil
+	})
+}
+
+// LoadChannelFeeds returns the feeds of a channel in the order they were added.
+func (p *Plugin) LoadChannelFeeds(channelID string) []Feed {
 	feeds := []Feed{}
-	err := p.client.KV.Get(KVKey, &feeds)
+	ids, err := p.getChannelFeedIDs(channelID)
 	if err != nil {
 		p.client.Log.Error("Error loading feeds: " + err.Error())
+		return feeds
+	}
+	for _, id := range ids {
+		feed, err := p.GetFeed(id)
+		if err != nil {
+			p.client.Log.Error("Error loading feed: " + err.Error())
+			continue
+		}
+		if feed != nil {
+			feeds = append(feeds, *feed)
+		}
+	}
+	return feeds
+}
+
+// LoadFeeds returns every feed on the server, oldest first.
+func (p *Plugin) LoadFeeds() []Feed {
+	feeds := []Feed{}
+	for page := 0; ; page++ {
+		keys, err := p.client.KV.ListKeys(page, kvListPageSize)
+		if err != nil {
+			p.client.Log.Error("Error loading feeds: " + err.Error())
+			break
+		}
+		for _, key := range keys {
+			if !strings.HasPrefix(key, FeedKeyPrefix) {
+				continue
+			}
+			feed, err := p.GetFeed(strings.TrimPrefix(key, FeedKeyPrefix))
+			if err != nil {
+				p.client.Log.Error("Error loading feed: " + err.Error())
+				continue
+			}
+			if feed != nil {
+				feeds = append(feeds, *feed)
+			}
+		}
+		if len(keys) < kvListPageSize {
+			break
+		}
 	}
+	slices.SortFunc(feeds, func(a, b Feed) int {
+		return cmp.Or(cmp.Compare(a.CreatedAt, b.CreatedAt), strings.Compare(a.ID, b.ID))
+	})
 	return feeds
 }
+
+// MigrateLegacyFeeds moves the subscriptions stored under KVKey to their own
+// keys and removes the legacy blob. It runs under a cluster mutex, so nodes
+// activating together migrate the feeds once.
+func (p *Plugin) MigrateLegacyFeeds() error {
+	mutex, err := cluster.NewMutex(p.API, "MigrateLegacyFeeds")
+	if err != nil {
+		return err
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	return p.migrateLegacyFeeds()
+}
+
+// legacyFeedID derives the ID of a migrated feed from its channel and URL,
+// so a migration resumed after a failure doesn't create the feed again.
+func legacyFeedID(feed *Feed) string {
+	sum := sha256.Sum256([]byte(feed.ChannelID + "\x00" + feed.URL))
+	return hex.EncodeToString(sum[:13])
+}
+
+func (p *Plugin) migrateLegacyFeeds() error {
+	// another node may have migrated the feeds while we waited for the lock
+	feeds := []Feed{}
+	err := p.client.KV.Get(KVKey, &feeds)
+	if err != nil {
+		return err
+	}
+	if len(feeds) == 0 {
+		return nil
+	}
+	for i := range feeds {
+		feed := &feeds[i]
+		feed.ID = legacyFeedID(feed)
+		stored, err := p.GetFeed(feed.ID)
+		if err != nil {
+			return err
+		}
+		if stored != nil {
+			// migrated by an attempt that failed later on
+			p.setFeedDue(stored.ID, stored)
+			err = p.indexFeed(stored)
+		} else {
+			err = p.CreateFeed(feed)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	p.client.Log.Info(fmt.Sprintf("Migrated %d feeds", len(feeds)))
+	return p.client.KV.Delete(KVKey)
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mattermost/mattermost/server/public/plugin/plugintest"
+	"github.com/mattermost/mattermost/server/public/pluginapi"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func setupPlugin(t *testing.T) (*Plugin, *plugintest.API) {
+	api := &plugintest.API{}
+	t.Cleanup(func() { api.AssertExpectations(t) })
+	p := &Plugin{}
+	p.SetAPI(api)
+	p.client = pluginapi.NewClient(api, nil)
+	return p, api
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	return data
+}
+
+func withOldValue(old []byte) interface{} {
+	return mock.MatchedBy(func(opts model.PluginKVSetOptions) bool {
+		return opts.Atomic && string(opts.OldValue) == string(old)
+	})
+}
+
+// expectScheduleUpdates expects the schedule to be updated n times.
+func expectScheduleUpdates(api *plugintest.API, n int) {
+	api.On("KVGet", ScheduleKey).Return(nil, nil).Times(n)
+	api.On("KVSetWithOptions", ScheduleKey, mock.Anything, withOldValue(nil)).Return(true, nil).Times(n)
+}
+
+func TestUpdateFeedRetriesOnConflict(t *testing.T) {
+	p, api := setupPlugin(t)
+
+	// The job loaded the feed, then a command changed its URL before the
+	// job wrote its fetch state back.
+	before := mustMarshal(t, &Feed{ID: "feed1", URL: "https://old.example.com/rss", ChannelID: "channel1"})
+	after := mustMarshal(t, &Feed{ID: "feed1", URL: "https://new.example.com/rss", ChannelID: "channel1"})
+	api.On("KVGet", "feed_feed1").Return(before, nil).Once()
+	api.On("KVSetWithOptions", "feed_feed1", mock.Anything, withOldValue(before)).Return(false, nil).Once()
+	api.On("KVGet", "feed_feed1").Return(after, nil).Once()
+	var saved Feed
+	api.On("KVSetWithOptions", "feed_feed1", mock.Anything, withOldValue(after)).Run(func(args mock.Arguments) {
+		require.NoError(t, json.Unmarshal(args.Get(1).([]byte), &saved))
+	}).Return(true, nil).Once()
+
+	feed, err := p.UpdateFeed("feed1", func(feed *Feed) error {
+		feed.Updated = 42
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "https://new.example.com/rss", feed.URL)
+	assert.Equal(t, "https://new.example.com/rss", saved.URL)
+	assert.Equal(t, int64(42), saved.Updated)
+}
+
+func TestUpdateFeedDeletedMeanwhile(t *testing.T) {
+	p, api := setupPlugin(t)
+
+	// A command deleted the feed while the job was fetching it.
+	api.On("KVGet", "feed_feed1").Return(nil, nil).Once()
+
+	_, err := p.UpdateFeed("feed1", func(feed *Feed) error {
+		feed.Updated = 42
+		return nil
+	})
+	assert.ErrorIs(t, err, ErrFeedNotFound)
+	api.AssertNotCalled(t, "KVSetWithOptions", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestCreateFeedConcurrentAdd(t *testing.T) {
+	p, api := setupPlugin(t)
+
+	// Another node adds a feed to the same channel between our read and
+	// write of the channel index.
+	settings := mustMarshal(t, &ChannelSettings{LastFeedNumber: 2})
+	api.On("KVGet", "settings_channel1").Return(settings, nil).Once()
+	api.On("KVSetWithOptions", "settings_channel1", mock.Anything, withOldValue(settings)).Return(true, nil).Once()
+	api.On("KVSetWithOptions", mock.MatchedBy(func(key string) bool {
+		return strings.HasPrefix(key, FeedKeyPrefix)
+	}), mock.Anything, mock.Anything).Return(true, nil).Once()
+	before := mustMarshal(t, []string{"feed1"})
+	after := mustMarshal(t, []string{"feed1", "feed2"})
+	api.On("KVGet", "channel_channel1").Return(before, nil).Once()
+	api.On("KVSetWithOptions", "channel_channel1", mock.Anything, withOldValue(before)).Return(false, nil).Once()
+	api.On("KVGet", "channel_channel1").Return(after, nil).Once()
+	var saved []string
+	api.On("KVSetWithOptions", "channel_channel1", mock.Anything, withOldValue(after)).Run(func(args mock.Arguments) {
+		require.NoError(t, json.Unmarshal(args.Get(1).([]byte), &saved))
+	}).Return(true, nil).Once()
+	expectScheduleUpdates(api, 1)
+
+	feed := &Feed{URL: "https://example.com/rss", ChannelID: "channel1"}
+	require.NoError(t, p.CreateFeed(feed))
+	assert.Equal(t, []string{"feed1", "feed2", feed.ID}, saved)
+	assert.Equal(t, 3, feed.Number)
+}
+
+func TestDeleteFeedConcurrentAdd(t *testing.T) {
+	p, api := setupPlugin(t)
+
+	before := mustMarshal(t, []string{"feed1", "feed2"})
+	after := mustMarshal(t, []string{"feed1", "feed2", "feed3"})
+	api.On("KVGet", "channel_channel1").Return(before, nil).Once()
+	api.On("KVSetWithOptions", "channel_channel1", mock.Anything, withOldValue(before)).Return(false, nil).Once()
+	api.On("KVGet", "channel_channel1").Return(after, nil).Once()
+	var saved []string
+	api.On("KVSetWithOptions", "channel_channel1", mock.Anything, withOldValue(after)).Run(func(args mock.Arguments) {
+		require.NoError(t, json.Unmarshal(args.Get(1).([]byte), &saved))
+	}).Return(true, nil).Once()
+	api.On("KVSetWithOptions", "digest_feed1", []byte(nil), mock.Anything).Return(true, nil).Once()
+	api.On("KVSetWithOptions", "thread_feed1", []byte(nil), mock.Anything).Return(true, nil).Once()
+	api.On("KVSetWithOptions", "feed_feed1", []byte(nil), mock.Anything).Return(true, nil).Once()
+	expectScheduleUpdates(api, 1)
+
+	require.NoError(t, p.DeleteFeed(&Feed{ID: "feed1", ChannelID: "channel1"}))
+	assert.Equal(t, []string{"feed2", "feed3"}, saved)
+}
+
+func TestMigrateLegacyFeedsResumes(t *testing.T) {
+	p, api := setupPlugin(t)
+
+	// A previous migration stored the first feed, then failed before adding
+	// it to the channel index and before migrating the second one.
+	first := Feed{URL: "https://example.com/a", ChannelID: "channel1"}
+	second := Feed{URL: "https://example.com/b", ChannelID: "channel1"}
+	api.On("KVGet", KVKey).Return(mustMarshal(t, []Feed{first, second}), nil).Once()
+	firstID, secondID := legacyFeedID(&first), legacyFeedID(&second)
+	stored := first
+	stored.ID = firstID
+	stored.Number = 1
+	api.On("KVGet", "feed_"+firstID).Return(mustMarshal(t, &stored), nil).Once()
+	api.On("KVGet", "channel_channel1").Return(nil, nil).Once()
+	api.On("KVSetWithOptions", "channel_channel1", mustMarshal(t, []string{firstID}), withOldValue(nil)).Return(true, nil).Once()
+	api.On("KVGet", "feed_"+secondID).Return(nil, nil).Once()
+	settings := mustMarshal(t, &ChannelSettings{LastFeedNumber: 1})
+	api.On("KVGet", "settings_channel1").Return(settings, nil).Once()
+	api.On("KVSetWithOptions", "settings_channel1", mock.Anything, withOldValue(settings)).Return(true, nil).Once()
+	api.On("KVSetWithOptions", "feed_"+secondID, mock.Anything, mock.Anything).Return(true, nil).Once()
+	index := mustMarshal(t, []string{firstID})
+	api.On("KVGet", "channel_channel1").Return(index, nil).Once()
+	api.On("KVSetWithOptions", "channel_channel1", mustMarshal(t, []string{firstID, secondID}), withOldValue(index)).Return(true, nil).Once()
+	expectScheduleUpdates(api, 2)
+	api.On("LogInfo", mock.Anything).Return().Once()
+	api.On("KVSetWithOptions", KVKey, []byte(nil), mock.Anything).Return(true, nil).Once()
+
+	require.NoError(t, p.migrateLegacyFeeds())
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// ListPageSize is the number of feeds on a page of /feed list.
+const ListPageSize = 50
+
+// ListFeeds handles /feed list. System admins can list every feed of the
+// server with --all.
+func (p *Plugin) ListFeeds(args *model.CommandArgs, flags map[string]string) *model.CommandResponse {
+	page := 1
+	for name, value := range flags {
+		switch name {
+		case "all":
+		case "page":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return response("Error: invalid page " + value)
+			}
+			page = n
+		default:
+			return response("Error: unknown option --" + name)
+		}
+	}
+	_, all := flags["all"]
+	var feeds []Feed
+	title := "Feeds in this channel"
+	if all {
+		if !p.API.HasPermissionTo(args.UserId, model.PermissionManageSystem) {
+			return response("Error: only system admins can list the feeds of every channel")
+		}
+		feeds = p.LoadFeeds()
+		title = "Feeds on this server"
+	} else {
+		feeds = p.LoadChannelFeeds(args.ChannelId)
+	}
+	if len(feeds) == 0 {
+		return response("There are no feeds yet, add one with `/feed add <url>`.")
+	}
+	pages := (len(feeds) + ListPageSize - 1) / ListPageSize
+	if page > pages {
+		return response(fmt.Sprintf("Error: there are only %d pages", pages))
+	}
+	feeds = feeds[(page-1)*ListPageSize : min(page*ListPageSize, len(feeds))]
+	location, err := time.LoadLocation(p.getChannelTimezone(args.ChannelId))
+	if err != nil {
+		location = time.UTC
+	}
+	users := map[string]string{}
+	channels := map[string]string{}
+	text := title + ":\n\n"
+	if all {
+		text += "| Channel "
+	}
+	text += "| # | Title | URL | Added | Last success | Last item | Last error | Delivery | Status |\n"
+	if all {
+		text += "|:--"
+	}
+	text += "|--:|:--|:--|:--|:--|:--|:--|:--|:--|\n"
+	for _, feed := range feeds {
+		if all {
+			if _, ok := channels[feed.ChannelID]; !ok {
+				channels[feed.ChannelID] = p.getChannelName(feed.ChannelID)
+			}
+			text += "| " + tableCell(channels[feed.ChannelID]) + " "
+		}
+		if _, ok := users[feed.CreatorID]; !ok {
+			users[feed.CreatorID] = p.GetUserName(feed.CreatorID)
+		}
+		lastError := "—"
+		if feed.LastErrorAt > feed.LastSuccessAt {
+			lastError = formatListTime(feed.LastErrorAt, location) + " " + tableCell(truncate(80, feed.LastError))
+		}
+		text += fmt.Sprintf("| %d | %s | %s | @%s %s | %s | %s | %s | %s | %s |\n",
+			feed.Number,
+			tableCell(feed.Title),
+			feed.URL,
+			users[feed.CreatorID],
+			formatListTime(feed.CreatedAt, location),
+			formatListTime(feed.LastSuccessAt, location),
+			formatListTime(feed.LastItemAt, location),
+			lastError,
+			formatDelivery(&feed),
+			tableCell(formatStatus(&feed, location)),
+		)
+	}
+	if pages > 1 {
+		text += fmt.Sprintf("\nPage %d of %d.", page, pages)
+		if page < pages {
+			text += fmt.Sprintf(" Use `--page %d` to see the next one.", page+1)
+		}
+	}
+	return response(text)
+}
+
+// getChannelName returns the team and display name of a channel.
+func (p *Plugin) getChannelName(channelID string) string {
+	channel, err := p.client.Channel.Get(channelID)
+	if err != nil {
+		return channelID
+	}
+	if channel.TeamId == "" {
+		return channel.DisplayName
+	}
+	team, err := p.client.Team.Get(channel.TeamId)
+	if err != nil {
+		return channel.DisplayName
+	}
+	return team.DisplayName + " / " + channel.DisplayName
+}
+
+// formatStatus tells whether a feed is fetched.
+func formatStatus(feed *Feed, location *time.Location) string {
+	switch {
+	case feed.SnoozeUntil > time.Now().Unix():
+		return "snoozed until " + formatListTime(feed.SnoozeUntil, location)
+	case feed.Paused && feed.PausedReason != "":
+		return "paused, " + truncate(80, feed.PausedReason)
+	case feed.Paused:
+		return "paused"
+	case feed.Failures > 0:
+		return fmt.Sprintf("failing, %d times in a row", feed.Failures)
+	}
+	return "active"
+}
+
+func formatListTime(unix int64, location *time.Location) string {
+	if unix == 0 {
+		return "—"
+	}
+	return time.Unix(unix, 0).In(location).Format("2006-01-02 15:04")
+}
+
+// tableCell makes s fit in a cell of a markdown table.
+func tableCell(s string) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if s == "" {
+		return "—"
+	}
+	return escapeMarkdown(s)
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// MaxSummaryLength is the number of characters of an item summary posted
+// before it is cut with a "Read more" link.
+const MaxSummaryLength = 1000
+
+// droppedElements are never rendered, including their content.
+var droppedElements = map[atom.Atom]bool{
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Noscript: true,
+	atom.Iframe:   true,
+	atom.Object:   true,
+	atom.Embed:    true,
+	atom.Form:     true,
+	atom.Head:     true,
+	atom.Template: true,
+	atom.Svg:      true,
+}
+
+var blockElements = map[atom.Atom]bool{
+	atom.P:          true,
+	atom.Div:        true,
+	atom.Section:    true,
+	atom.Article:    true,
+	atom.Header:     true,
+	atom.Footer:     true,
+	atom.Figure:     true,
+	atom.Figcaption: true,
+	atom.Table:      true,
+	atom.Tr:         true,
+	atom.Dl:         true,
+	atom.Dt:         true,
+	atom.Dd:         true,
+}
+
+var headingLevels = map[atom.Atom]int{
+	atom.H1: 1, atom.H2: 2, atom.H3: 3, atom.H4: 4, atom.H5: 5, atom.H6: 6,
+}
+
+var blankLines = regexp.MustCompile(`\n{3,}`)
+var spaces = regexp.MustCompile(`[ \t\r\n\f]+`)
+
+// markdownWriter converts an HTML tree to Mattermost markdown.
+type markdownWriter struct {
+	sb    strings.Builder
+	base  *url.URL
+	lists []atom.Atom
+	// counters holds the next number of each open ordered list.
+	counters []int
+}
+
+// htmlToMarkdown converts the HTML of an item to Mattermost markdown. Only
+// formatting is kept: scripts, styles, embeds and unknown elements are
+// dropped, and links and images are restricted to http, https and mailto.
+// Relative links are resolved against base, which may be empty.
+func htmlToMarkdown(text string, base string) string {
+	doc, err := html.Parse(strings.NewReader(text))
+	if err != nil {
+		return ""
+	}
+	w := &markdownWriter{}
+	if u, err := url.Parse(base); err == nil && base != "" {
+		w.base = u
+	}
+	w.walkChildren(doc)
+	lines := strings.Split(w.sb.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	return strings.TrimSpace(blankLines.ReplaceAllString(strings.Join(lines, "\n"), "\n\n"))
+}
+
+func (w *markdownWriter) walkChildren(n *html.Node) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		w.walk(c)
+	}
+}
+
+// block ends the current line and leaves a blank line before what follows.
+func (w *markdownWriter) block() {
+	if w.sb.Len() > 0 {
+		w.sb.WriteString("\n\n")
+	}
+}
+
+func (w *markdownWriter) resolve(href string) string {
+	u, err := url.Parse(strings.TrimSpace(href))
+	if err != nil {
+		return ""
+	}
+	if w.base != nil {
+		u = w.base.ResolveReference(u)
+	}
+	switch u.Scheme {
+	case "http", "https", "mailto":
+		return u.String()
+	}
+	return ""
+}
+
+// inline renders the children of n on their own, to wrap them.
+func (w *markdownWriter) inline(n *html.Node) string {
+	inner := &markdownWriter{base: w.base, lists: w.lists, counters: w.counters}
+	inner.walkChildren(n)
+	return strings.TrimSpace(spaces.ReplaceAllString(inner.sb.String(), " "))
+}
+
+func (w *markdownWriter) walk(n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		w.sb.WriteString(escapeMarkdown(spaces.ReplaceAllString(n.Data, " ")))
+		return
+	case html.ElementNode:
+	default:
+		w.walkChildren(n)
+		return
+	}
+	if droppedElements[n.DataAtom] {
+		return
+	}
+	if level, ok := headingLevels[n.DataAtom]; ok {
+		w.block()
+		w.sb.WriteString(strings.Repeat("#", level) + " " + w.inline(n))
+		w.block()
+		return
+	}
+	if blockElements[n.DataAtom] {
+		w.block()
+		w.walkChildren(n)
+		w.block()
+		return
+	}
+	switch n.DataAtom {
+	case atom.Br:
+		w.sb.WriteString("\n")
+	case atom.Hr:
+		w.block()
+		w.sb.WriteString("---")
+		w.block()
+	case atom.Strong, atom.B:
+		w.wrap(n, "**")
+	case atom.Em, atom.I:
+		w.wrap(n, "_")
+	case atom.Del, atom.S, atom.Strike:
+		w.wrap(n, "~~")
+	case atom.Code:
+		if text := strings.TrimSpace(textContent(n)); text != "" {
+			w.sb.WriteString("`" + strings.ReplaceAll(text, "`", "'") + "`")
+		}
+	case atom.Pre:
+		w.block()
+		code := strings.Trim(textContent(n), "\n")
+		fence := codeFence(code)
+		w.sb.WriteString(fence + "\n" + code + "\n" + fence)
+		w.block()
+	case atom.A:
+		text := w.inline(n)
+		href := w.resolve(getAttr(n, "href"))
+		if href == "" || text == "" {
+			w.sb.WriteString(text)
+			return
+		}
+		w.sb.WriteString("[" + text + "](" + href + ")")
+	case atom.Img:
+		src := w.resolve(getAttr(n, "src"))
+		if src != "" {
+			w.sb.WriteString("![" + escapeMarkdown(getAttr(n, "alt")) + "](" + src + ")")
+		}
+	case atom.Ul, atom.Ol:
+		w.block()
+		w.lists = append(w.lists, n.DataAtom)
+		w.counters = append(w.counters, 1)
+		w.walkChildren(n)
+		w.lists = w.lists[:len(w.lists)-1]
+		w.counters = w.counters[:len(w.counters)-1]
+		w.block()
+	case atom.Li:
+		w.listItem(n)
+	case atom.Blockquote:
+		w.block()
+		inner := &markdownWriter{base: w.base}
+		inner.walkChildren(n)
+		quoted := strings.TrimSpace(blankLines.ReplaceAllString(inner.sb.String(), "\n\n"))
+		w.sb.WriteString("> " + strings.ReplaceAll(quoted, "\n", "\n> "))
+		w.block()
+	case atom.Td, atom.Th:
+		w.walkChildren(n)
+		w.sb.WriteString(" ")
+	default:
+		w.walkChildren(n)
+	}
+}
+
+func (w *markdownWriter) wrap(n *html.Node, marker string) {
+	text := w.inline(n)
+	if text != "" {
+		w.sb.WriteString(marker + text + marker)
+	}
+}
+
+func (w *markdownWriter) listItem(n *html.Node) {
+	depth := len(w.lists)
+	marker := "- "
+	if depth > 0 && w.lists[depth-1] == atom.Ol {
+		marker = strconv.Itoa(w.counters[depth-1]) + ". "
+		w.counters[depth-1]++
+	}
+	inner := &markdownWriter{base: w.base, lists: w.lists, counters: w.counters}
+	inner.walkChildren(n)
+	text := strings.TrimSpace(blankLines.ReplaceAllString(inner.sb.String(), "\n\n"))
+	text = strings.ReplaceAll(text, "\n\n", "\n")
+	// nested lists and paragraphs are indented under the marker
+	text = strings.ReplaceAll(text, "\n", "\n"+strings.Repeat(" ", len(marker)))
+	if !strings.HasSuffix(w.sb.String(), "\n") && w.sb.Len() > 0 {
+		w.sb.WriteString("\n")
+	}
+	w.sb.WriteString(marker + text + "\n")
+}
+
+// codeFence returns a fence for a code block, longer than any run of
+// backticks in code so the block can't be closed early.
+func codeFence(code string) string {
+	longest, run := 0, 0
+	for _, r := range code {
+		if r == '`' {
+			run++
+			longest = max(longest, run)
+		} else {
+			run = 0
+		}
+	}
+	return strings.Repeat("`", max(longest+1, 3))
+}
+
+// openFence returns the fence of the code block left open at the end of
+// markdown, if any.
+func openFence(markdown string) string {
+	open := ""
+	for _, line := range strings.Split(markdown, "\n") {
+		line = strings.TrimLeft(line, " ")
+		fence := line[:len(line)-len(strings.TrimLeft(line, "`"))]
+		switch {
+		case len(fence) < 3:
+		case open == "":
+			open = fence
+		case len(fence) >= len(open) && strings.TrimSpace(line[len(fence):]) == "":
+			open = ""
+		}
+	}
+	return open
+}
+
+func getAttr(n *html.Node, key string) string {
+	for _, attr := range n.Attr {
+		if attr.Key == key {
+			return attr.Val
+		}
+	}
+	return ""
+}
+
+func textContent(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var sb strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && droppedElements[c.DataAtom] {
+			continue
+		}
+		sb.WriteString(textContent(c))
+	}
+	return sb.String()
+}
+
+// cutMarkdown shortens markdown to at most limit characters, preferring to
+// cut between paragraphs, then between words. A "Read more" link to link is
+// added when the text was cut.
+func cutMarkdown(text string, limit int, link string) string {
+	if utf8.RuneCountInString(text) <= limit {
+		return text
+	}
+	readMore := "…"
+	if link != "" {
+		readMore = "…\n\n[Read more](" + link + ")"
+	}
+	runes := []rune(text)
+	// room is left to close a code block the cut leaves open
+	reserve := 0
+	for {
+		cut := string(runes[:max(limit-utf8.RuneCountInString(readMore)-reserve, 0)])
+		if i := strings.LastIndex(cut, "\n\n"); i > len(cut)/2 {
+			cut = cut[:i]
+		} else if i := strings.LastIndexAny(cut, " \n"); i > len(cut)/2 {
+			cut = cut[:i]
+		}
+		cut = strings.TrimSpace(cut)
+		fence := openFence(cut)
+		if fence == "" {
+			return cut + readMore
+		}
+		if len(fence)+1 <= reserve {
+			return cut + "\n" + fence + readMore
+		}
+		reserve = len(fence) + 1
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTMLToMarkdown(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		html     string
+		base     string
+		expected string
+	}{
+		{
+			name:     "headings",
+			html:     "<h1>Title</h1><p>Text</p><h3>Sub <em>title</em></h3>",
+			expected: "# Title\n\nText\n\n### Sub _title_",
+		},
+		{
+			name:     "formatting",
+			html:     "<p><strong>bold</strong>, <i>italic</i>, <del>gone</del> and <code>x := 1</code></p>",
+			expected: "**bold**, _italic_, ~~gone~~ and `x := 1`",
+		},
+		{
+			name:     "unordered list",
+			html:     "<ul><li>one</li><li>two</li></ul>",
+			expected: "- one\n- two",
+		},
+		{
+			name:     "ordered list",
+			html:     "<ol><li>one</li><li>two</li><li>three</li></ol>",
+			expected: "1. one\n2. two\n3. three",
+		},
+		{
+			name:     "nested lists",
+			html:     "<ul><li>one<ol><li>first</li><li>second</li></ol></li><li>two</li></ul>",
+			expected: "- one\n  1. first\n  2. second\n- two",
+		},
+		{
+			name:     "absolute link",
+			html:     `<a href="https://example.com/a">A</a>`,
+			expected: "[A](https://example.com/a)",
+		},
+		{
+			name:     "relative link",
+			html:     `<a href="/posts/1">post</a> <img src="img.png" alt="pic">`,
+			base:     "https://example.com/blog/",
+			expected: "[post](https://example.com/posts/1) ![pic](https://example.com/blog/img.png)",
+		},
+		{
+			name:     "relative link without base",
+			html:     `<a href="/posts/1">post</a>`,
+			expected: "post",
+		},
+		{
+			name:     "javascript link",
+			html:     `<a href="javascript:alert(1)">click</a> <img src="javascript:alert(1)">`,
+			expected: "click",
+		},
+		{
+			name:     "dropped elements",
+			html:     "<p>kept</p><script>alert(1)</script><style>p { color: red }</style><iframe src=\"https://example.com\"></iframe>",
+			expected: "kept",
+		},
+		{
+			name:     "blockquote",
+			html:     "<blockquote><p>first</p><p>second</p></blockquote><p>after</p>",
+			expected: "> first\n>\n> second\n\nafter",
+		},
+		{
+			name:     "code block",
+			html:     "<pre>a := 1\nb := 2</pre>",
+			expected: "```\na := 1\nb := 2\n```",
+		},
+		{
+			name:     "code block with fences",
+			html:     "<pre>```\nquoted\n````</pre>",
+			expected: "`````\n```\nquoted\n````\n`````",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, htmlToMarkdown(tc.html, tc.base))
+		})
+	}
+}
+
+func TestCutMarkdown(t *testing.T) {
+	long := strings.Repeat("word ", 30) + "\n\n" + strings.Repeat("more ", 30)
+	code := "Intro\n\n```\n" + strings.Repeat("line of code\n", 20) + "```"
+	longFence := "Intro\n\n`````\n" + strings.Repeat("```\n", 40) + "`````"
+	for _, tc := range []struct {
+		name     string
+		text     string
+		limit    int
+		link     string
+		expected string
+	}{
+		{
+			name:     "short enough",
+			text:     "short",
+			limit:    10,
+			link:     "https://example.com",
+			expected: "short",
+		},
+		{
+			name:     "cut between paragraphs",
+			text:     long,
+			limit:    200,
+			expected: strings.TrimSpace(strings.Repeat("word ", 30)) + "…",
+		},
+		{
+			name:     "cut between words",
+			text:     strings.Repeat("word ", 30),
+			limit:    22,
+			expected: "word word word word…",
+		},
+		{
+			name:     "read more",
+			text:     long,
+			limit:    200,
+			link:     "https://example.com/a",
+			expected: strings.TrimSpace(strings.Repeat("word ", 30)) + "…\n\n[Read more](https://example.com/a)",
+		},
+		{
+			name:     "open code block",
+			text:     code,
+			limit:    60,
+			expected: "Intro\n\n```\nline of code\nline of code\nline of code\nline\n```…",
+		},
+		{
+			name:     "open code block with a long fence",
+			text:     longFence,
+			limit:    50,
+			expected: "Intro\n\n`````\n" + strings.Repeat("```\n", 7) + "`````…",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cut := cutMarkdown(tc.text, tc.limit, tc.link)
+			assert.Equal(t, tc.expected, cut)
+			if cut != tc.text {
+				assert.LessOrEqual(t, utf8.RuneCountInString(cut), tc.limit)
+			}
+		})
+	}
+}
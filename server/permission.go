@@ -0,0 +1,68 @@
+package main
+
+import (
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// PermissionLevels are the roles that can be required to manage feeds, from
+// the least to the most privileged.
+var PermissionLevels = []string{"anyone", "channel_admin", "team_admin", "system_admin"}
+
+var permissionLevelNames = map[string]string{
+	"channel_admin": "channel admins",
+	"team_admin":    "team admins",
+	"system_admin":  "system admins",
+}
+
+// manageCommands are the subcommands that change feeds or their settings.
+var manageCommands = map[string]bool{
+	"add":      true,
+	"edit":     true,
+	"del":      true,
+	"pause":    true,
+	"snooze":   true,
+	"resume":   true,
+	"template": true,
+	"filter":   true,
+}
+
+// hasPermissionLevel reports whether a user has at least the role level in
+// a channel. Higher roles include the permissions of lower ones. Unknown
+// levels are reserved to system admins.
+func (p *Plugin) hasPermissionLevel(userID, teamID, channelID, level string) bool {
+	switch level {
+	case "", "anyone":
+		return true
+	case "channel_admin":
+		return p.API.HasPermissionToChannel(userID, channelID, model.PermissionManageChannelRoles)
+	case "team_admin":
+		return p.API.HasPermissionToTeam(userID, teamID, model.PermissionManageTeam)
+	}
+	return p.API.HasPermissionTo(userID, model.PermissionManageSystem)
+}
+
+// checkManagePermission returns an error response when the user may not
+// manage the feeds of the channel.
+func (p *Plugin) checkManagePermission(args *model.CommandArgs) *model.CommandResponse {
+	level := p.getConfiguration().ManagePermission
+	if p.hasPermissionLevel(args.UserId, args.TeamId, args.ChannelId, level) {
+		return nil
+	}
+	name, ok := permissionLevelNames[level]
+	if !ok {
+		name = permissionLevelNames["system_admin"]
+	}
+	return response("Error: only " + name + " can manage the feeds of this channel.")
+}
+
+// checkDeletePermission returns an error response when the server only
+// lets creators and admins delete feeds and the user is neither.
+func (p *Plugin) checkDeletePermission(args *model.CommandArgs, feed *Feed) *model.CommandResponse {
+	if !p.getConfiguration().OnlyCreatorCanDelete || feed.CreatorID == args.UserId {
+		return nil
+	}
+	if p.hasPermissionLevel(args.UserId, args.TeamId, args.ChannelId, "channel_admin") {
+		return nil
+	}
+	return response("Error: only @" + p.GetUserName(feed.CreatorID) + ", who added " + feed.URL + ", or an admin can delete it.")
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckManagePermission(t *testing.T) {
+	for _, tc := range []struct {
+		level        string
+		channelAdmin bool
+		teamAdmin    bool
+		systemAdmin  bool
+		allowed      bool
+	}{
+		{level: "", allowed: true},
+		{level: "anyone", allowed: true},
+		{level: "channel_admin", allowed: false},
+		{level: "channel_admin", channelAdmin: true, allowed: true},
+		{level: "team_admin", channelAdmin: true, allowed: false},
+		{level: "team_admin", teamAdmin: true, allowed: true},
+		{level: "system_admin", teamAdmin: true, allowed: false},
+		{level: "system_admin", systemAdmin: true, allowed: true},
+		{level: "unknown", systemAdmin: false, allowed: false},
+	} {
+		t.Run(tc.level, func(t *testing.T) {
+			p, api := setupPlugin(t)
+			p.setConfiguration(&configuration{ManagePermission: tc.level})
+			api.On("HasPermissionToChannel", "user1", "channel1", model.PermissionManageChannelRoles).Return(tc.channelAdmin).Maybe()
+			api.On("HasPermissionToTeam", "user1", "team1", model.PermissionManageTeam).Return(tc.teamAdmin).Maybe()
+			api.On("HasPermissionTo", "user1", model.PermissionManageSystem).Return(tc.systemAdmin).Maybe()
+
+			resp := p.checkManagePermission(&model.CommandArgs{UserId: "user1", TeamId: "team1", ChannelId: "channel1"})
+			assert.Equal(t, tc.allowed, resp == nil)
+		})
+	}
+}
+
+func TestCheckDeletePermission(t *testing.T) {
+	p, api := setupPlugin(t)
+	feed := &Feed{CreatorID: "creator", URL: "https://example.com/rss"}
+	args := func(userID string) *model.CommandArgs {
+		return &model.CommandArgs{UserId: userID, TeamId: "team1", ChannelId: "channel1"}
+	}
+
+	p.setConfiguration(&configuration{})
+	assert.Nil(t, p.checkDeletePermission(args("someone"), feed))
+
+	p.setConfiguration(&configuration{OnlyCreatorCanDelete: true})
+	assert.Nil(t, p.checkDeletePermission(args("creator"), feed))
+	api.On("HasPermissionToChannel", "admin", "channel1", model.PermissionManageChannelRoles).Return(true)
+	assert.Nil(t, p.checkDeletePermission(args("admin"), feed))
+	api.On("HasPermissionToChannel", "someone", "channel1", model.PermissionManageChannelRoles).Return(false)
+	api.On("GetUser", "creator").Return(&model.User{Id: "creator", Username: "alice"}, nil)
+	resp := p.checkDeletePermission(args("someone"), feed)
+	if assert.NotNil(t, resp) {
+		assert.Contains(t, resp.Text, "@alice")
+	}
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"net/http"
+
 	"github.com/mattermost/mattermost/server/public/plugin"
 	"github.com/mattermost/mattermost/server/public/pluginapi"
 )
@@ -8,7 +10,13 @@ import (
 func (p *Plugin) OnActivate() error {
 	p.client = pluginapi.NewClient(p.API, p.Driver)
 
-	err := p.RegisterFeedCommand()
+	err := p.MigrateLegacyFeeds()
+
+	if err != nil {
+		return err
+	}
+
+	err = p.RegisterFeedCommand()
 
 	if err != nil {
 		return err
@@ -34,7 +42,16 @@ func (p *Plugin) OnActivate() error {
 		return err
 	}
 
+	digestJob, err := p.ScheduleDigestJob()
+
+	if err != nil {
+		return err
+	}
+
+	p.jobLock.Lock()
 	p.backgroundJob = job
+	p.digestJob = digestJob
+	p.jobLock.Unlock()
 
 	return err
 }
@@ -51,6 +68,15 @@ func (p *Plugin) OnDeactivate() error {
 	return nil
 }
 
+func (p *Plugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case AutocompleteFeedsPath:
+		p.serveAutocompleteFeeds(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
 func main() {
 	plugin.ClientMain(&Plugin{})
 }
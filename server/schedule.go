@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/mmcdole/gofeed/rss"
+)
+
+// JobTick is how often the background job looks for feeds that are due.
+const JobTick = time.Minute
+
+// MinFetchInterval is the shortest interval a feed may be polled at.
+const MinFetchInterval = 2 * time.Minute
+
+// MaxAdaptiveInterval caps the interval requested by a publisher.
+const MaxAdaptiveInterval = 24 * time.Hour
+
+// MaxBackoff caps the delay before a failing feed is retried, unless the
+// publisher asks for a longer one with Retry-After.
+const MaxBackoff = 24 * time.Hour
+
+// feedHints are the polling hints a publisher puts in the feed body.
+type feedHints struct {
+	// Interval is the longest of <ttl> and sy:updatePeriod/sy:updateFrequency.
+	Interval  time.Duration
+	SkipHours []int
+	SkipDays  []int
+}
+
+var updatePeriods = map[string]time.Duration{
+	"hourly":  time.Hour,
+	"daily":   24 * time.Hour,
+	"weekly":  7 * 24 * time.Hour,
+	"monthly": 30 * 24 * time.Hour,
+	"yearly":  365 * 24 * time.Hour,
+}
+
+// parseHints reads <ttl>, <skipHours>, <skipDays> and the syndication
+// module from a feed body.
+func parseHints(body []byte, page *gofeed.Feed) feedHints {
+	hints := feedHints{}
+	if sy, ok := page.Extensions["sy"]; ok {
+		period := updatePeriods["daily"]
+		if values := sy["updatePeriod"]; len(values) > 0 {
+			if d, ok := updatePeriods[strings.TrimSpace(values[0].Value)]; ok {
+				period = d
+			}
+		}
+		frequency := 1
+		if values := sy["updateFrequency"]; len(values) > 0 {
+			if n, err := strconv.Atoi(strings.TrimSpace(values[0].Value)); err == nil && n > 0 {
+				frequency = n
+			}
+		}
+		hints.Interval = period / time.Duration(frequency)
+	}
+	if page.FeedType != "rss" {
+		return hints
+	}
+	channel, err := (&rss.Parser{}).Parse(bytes.NewReader(body))
+	if err != nil {
+		return hints
+	}
+	if ttl, err := strconv.Atoi(strings.TrimSpace(channel.TTL)); err == nil && ttl > 0 {
+		hints.Interval = max(hints.Interval, time.Duration(ttl)*time.Minute)
+	}
+	for _, hour := range channel.SkipHours {
+		if h, err := strconv.Atoi(strings.TrimSpace(hour)); err == nil && h >= 0 && h < 24 {
+			hints.SkipHours = append(hints.SkipHours, h)
+		}
+	}
+	for _, day := range channel.SkipDays {
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			if strings.EqualFold(strings.TrimSpace(day), d.String()) {
+				hints.SkipDays = append(hints.SkipDays, int(d))
+			}
+		}
+	}
+	return hints
+}
+
+// parseMaxAge returns the max-age of a Cache-Control header, or zero.
+func parseMaxAge(header http.Header) time.Duration {
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		if !strings.EqualFold(name, "max-age") {
+			continue
+		}
+		seconds, err := strconv.Atoi(strings.Trim(value, `"`))
+		if err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+	}
+	return 0
+}
+
+// parseInterval parses a polling interval such as 2m, 1h30m, 1d or 1w.
+func parseInterval(s string) (time.Duration, error) {
+	unit := time.Duration(0)
+	switch {
+	case strings.HasSuffix(s, "d"):
+		unit = 24 * time.Hour
+	case strings.HasSuffix(s, "w"):
+		unit = 7 * 24 * time.Hour
+	}
+	if unit != 0 {
+		n, err := strconv.Atoi(s[:len(s)-1])
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid interval: %s", s)
+		}
+		return time.Duration(n) * unit, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid interval: %s", s)
+	}
+	if d < MinFetchInterval {
+		return 0, fmt.Errorf("interval must be at least %s", MinFetchInterval)
+	}
+	return d, nil
+}
+
+// formatInterval describes the polling interval of a feed.
+func formatInterval(feed *Feed) string {
+	switch {
+	case feed.Adaptive:
+		return "auto"
+	case feed.Interval > 0:
+		return (time.Duration(feed.Interval) * time.Minute).String()
+	}
+	return "default"
+}
+
+// fetchInterval returns how long to wait before fetching the feed again.
+// maxAge is the Cache-Control max-age of the last response.
+func fetchInterval(feed *Feed, defaultInterval time.Duration, maxAge time.Duration) time.Duration {
+	interval := defaultInterval
+	if feed.Interval > 0 {
+		interval = time.Duration(feed.Interval) * time.Minute
+	}
+	if feed.Adaptive {
+		hinted := max(time.Duration(feed.PublisherInterval)*time.Second, maxAge)
+		if hinted > 0 {
+			interval = min(hinted, MaxAdaptiveInterval)
+		}
+	}
+	return max(interval, MinFetchInterval)
+}
+
+// backoff returns the delay before retrying a feed that failed the given
+// number of times in a row: the interval, doubled for every repeated failure.
+func backoff(interval time.Duration, failures int, retryAfter time.Duration) time.Duration {
+	delay := interval
+	for i := 1; i < failures && delay < MaxBackoff; i++ {
+		delay *= 2
+	}
+	return max(min(delay, MaxBackoff), retryAfter)
+}
+
+// nextFetch returns when the feed is due again. Adaptive feeds also skip the
+// hours and days the publisher asked to be left alone, which RSS defines in
+// GMT.
+func nextFetch(feed *Feed, now time.Time, interval time.Duration) int64 {
+	next := now.Add(interval).UTC()
+	if !feed.Adaptive || (len(feed.SkipHours) == 0 && len(feed.SkipDays) == 0) {
+		return next.Unix()
+	}
+	for range 7 * 24 {
+		if !slices.Contains(feed.SkipHours, next.Hour()) && !slices.Contains(feed.SkipDays, int(next.Weekday())) {
+			break
+		}
+		next = next.Truncate(time.Hour).Add(time.Hour)
+	}
+	return next.Unix()
+}
+
+// isDue reports whether the feed should be fetched at now.
+func isDue(feed *Feed, now time.Time) bool {
+	return !isSilenced(feed, now) && feed.NextFetch <= now.Unix()
+}
+
+// isSilenced reports whether a feed is paused or snoozed, so it is neither
+// fetched nor posts anything.
+func isSilenced(feed *Feed, now time.Time) bool {
+	return feed.Paused || feed.SnoozeUntil > now.Unix()
+}
+
+// rescheduleFeeds moves the next fetch of the feeds following the server
+// default interval to their last successful fetch plus the new interval,
+// after the default changed. Failing feeds keep their backoff.
+func (p *Plugin) rescheduleFeeds(interval time.Duration) {
+	followsDefault := func(feed *Feed) bool {
+		return feed.Interval == 0 && feed.Failures == 0 && feed.LastSuccessAt != 0
+	}
+	for _, feed := range p.LoadFeeds() {
+		if !followsDefault(&feed) {
+			continue
+		}
+		_, err := p.UpdateFeed(feed.ID, func(stored *Feed) error {
+			if followsDefault(stored) {
+				stored.NextFetch = nextFetch(stored, time.Unix(stored.LastSuccessAt, 0), fetchInterval(stored, interval, 0))
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, ErrFeedNotFound) {
+			p.client.Log.Error("Error saving feed: " + err.Error())
+		}
+	}
+}
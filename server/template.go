@@ -0,0 +1,274 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mattermost/mattermost/server/public/model"
+	"github.com/mmcdole/gofeed"
+)
+
+// DefaultTemplate renders the title and link of items, followed by their
+// summary.
+const DefaultTemplate = "{{.Item.Title}} | {{.Feed.Title}}\n{{.Item.Link}}{{if .Item.Summary}}\n\n{{.Item.Summary}}{{end}}"
+
+// TemplateItem is the item exposed to message templates as .Item. Summary
+// and Content are converted from HTML to markdown, and Summary is cut to
+// MaxSummaryLength.
+type TemplateItem struct {
+	Title      string
+	Link       string
+	Author     string
+	Summary    string
+	Content    string
+	Categories []string
+	Published  *time.Time
+	Enclosures []TemplateEnclosure
+	Image      string
+}
+
+type TemplateEnclosure struct {
+	URL    string
+	Type   string
+	Length string
+}
+
+// TemplateFeed is the feed exposed to message templates as .Feed.
+type TemplateFeed struct {
+	Title       string
+	Description string
+	Link        string
+	URL         string
+}
+
+type TemplateData struct {
+	Item TemplateItem
+	Feed TemplateFeed
+}
+
+func newTemplateData(feedURL string, page *gofeed.Feed, item *gofeed.Item) TemplateData {
+	data := TemplateData{
+		Item: TemplateItem{
+			Title:      item.Title,
+			Link:       item.Link,
+			Content:    htmlToMarkdown(item.Content, item.Link),
+			Categories: item.Categories,
+			Published:  getDate(item),
+		},
+		Feed: TemplateFeed{
+			Title:       page.Title,
+			Description: page.Description,
+			Link:        page.Link,
+			URL:         feedURL,
+		},
+	}
+	if len(item.Authors) > 0 && item.Authors[0] != nil {
+		data.Item.Author = item.Authors[0].Name
+	}
+	data.Item.Summary = htmlToMarkdown(item.Description, item.Link)
+	if data.Item.Summary == "" {
+		data.Item.Summary = data.Item.Content
+	}
+	data.Item.Summary = cutMarkdown(data.Item.Summary, MaxSummaryLength, item.Link)
+	for _, enclosure := range item.Enclosures {
+		data.Item.Enclosures = append(data.Item.Enclosures, TemplateEnclosure{
+			URL:    enclosure.URL,
+			Type:   enclosure.Type,
+			Length: enclosure.Length,
+		})
+	}
+	data.Item.Image = itemImage(item)
+	return data
+}
+
+// truncate shortens s to at most n characters, ending with an ellipsis when
+// it was cut.
+func truncate(n int, s string) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	if n <= 0 {
+		return ""
+	}
+	return string([]rune(s)[:n-1]) + "…"
+}
+
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "~", `\~`,
+	"[", `\[`, "]", `\]`, "#", `\#`, "<", `\<`, ">", `\>`, "|", `\|`,
+)
+
+// escapeMarkdown makes s render literally in a Mattermost post.
+func escapeMarkdown(s string) string {
+	return markdownEscaper.Replace(s)
+}
+
+func templateFuncs(location *time.Location) template.FuncMap {
+	return template.FuncMap{
+		"truncate": truncate,
+		"escape":   escapeMarkdown,
+		"join": func(sep string, values []string) string {
+			return strings.Join(values, sep)
+		},
+		// date formats t with a Go layout in the timezone of the channel.
+		"date": func(layout string, t *time.Time) string {
+			if t == nil {
+				return ""
+			}
+			return t.In(location).Format(layout)
+		},
+	}
+}
+
+// MaxTemplateOutput is the most a message template may render, in
+// characters. Longer messages are cut anyway, the limit stops templates that
+// would render for ever.
+const MaxTemplateOutput = 2 * model.PostMessageMaxRunesV2
+
+var errTemplateTooLong = fmt.Errorf("the template renders more than %d characters", MaxTemplateOutput)
+
+// limitedWriter fails once more than left characters are written to it.
+type limitedWriter struct {
+	w    io.Writer
+	left int
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	l.left -= utf8.RuneCount(p)
+	if l.left < 0 {
+		return 0, errTemplateTooLong
+	}
+	return l.w.Write(p)
+}
+
+func parseTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("item").Funcs(templateFuncs(time.UTC)).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	err = checkTemplate(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// checkTemplate rejects the constructs that let a template run for ever:
+// templates calling each other, which grows exponentially, and ranging over
+// anything but the lists of the data, such as a number.
+func checkTemplate(tmpl *template.Template) error {
+	if len(tmpl.Templates()) > 1 {
+		return errors.New("templates can't use {{define}} or {{block}}")
+	}
+	if tmpl.Tree == nil {
+		return nil
+	}
+	return checkNode(tmpl.Tree.Root)
+}
+
+func checkNode(node parse.Node) error {
+	switch node := node.(type) {
+	case *parse.ListNode:
+		if node == nil {
+			return nil
+		}
+		for _, child := range node.Nodes {
+			err := checkNode(child)
+			if err != nil {
+				return err
+			}
+		}
+	case *parse.TemplateNode:
+		return errors.New("templates can't use {{template}}")
+	case *parse.IfNode:
+		return checkBranch(&node.BranchNode)
+	case *parse.WithNode:
+		return checkBranch(&node.BranchNode)
+	case *parse.RangeNode:
+		if !isDataField(node.Pipe) {
+			return errors.New("{{range}} only ranges over fields such as .Item.Categories")
+		}
+		return checkBranch(&node.BranchNode)
+	}
+	return nil
+}
+
+func checkBranch(branch *parse.BranchNode) error {
+	err := checkNode(branch.List)
+	if err != nil {
+		return err
+	}
+	return checkNode(branch.ElseList)
+}
+
+// isDataField reports whether pipe is a field of the data, such as
+// .Item.Categories or $.Item.Enclosures.
+func isDataField(pipe *parse.PipeNode) bool {
+	if len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return false
+	}
+	switch arg := pipe.Cmds[0].Args[0].(type) {
+	case *parse.FieldNode:
+		return true
+	case *parse.VariableNode:
+		return len(arg.Ident) > 1 && arg.Ident[0] == "$"
+	}
+	return false
+}
+
+// renderTemplate renders a message template for an item. It fails when the
+// template renders more than MaxTemplateOutput characters.
+func renderTemplate(text string, location *time.Location, data TemplateData) (string, error) {
+	tmpl, err := parseTemplate(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	err = tmpl.Funcs(templateFuncs(location)).Execute(&limitedWriter{w: &buf, left: MaxTemplateOutput}, data)
+	if err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// itemTemplate returns the template and timezone items of the feed are
+// rendered with: the template of the feed, else of its channel, else of the
+// server.
+func (p *Plugin) itemTemplate(feed *Feed, settings *ChannelSettings) (string, *time.Location) {
+	location := time.UTC
+	if settings.Timezone != "" {
+		if loc, err := time.LoadLocation(settings.Timezone); err == nil {
+			location = loc
+		}
+	}
+	switch {
+	case feed.Template != "":
+		return feed.Template, location
+	case settings.Template != "":
+		return settings.Template, location
+	case p.getConfiguration().DefaultTemplate != "":
+		return p.getConfiguration().DefaultTemplate, location
+	}
+	return DefaultTemplate, location
+}
+
+// formatItem renders the message posted for an item, falling back to
+// DefaultTemplate if the configured template fails. Messages too long for a
+// post are cut with a "Read more" link.
+func (p *Plugin) formatItem(feed *Feed, settings *ChannelSettings, page *gofeed.Feed, item *gofeed.Item) string {
+	text, location := p.itemTemplate(feed, settings)
+	data := newTemplateData(feed.URL, page, item)
+	message, err := renderTemplate(text, location, data)
+	if err != nil {
+		p.client.Log.Warn("Error rendering template of feed: "+feed.URL, "error", err.Error())
+		message, _ = renderTemplate(DefaultTemplate, location, data)
+	}
+	return cutMarkdown(message, model.PostMessageMaxRunesV2, item.Link)
+}
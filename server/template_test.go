@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTemplate(t *testing.T) {
+	var chain strings.Builder
+	for i := 0; i < 40; i++ {
+		fmt.Fprintf(&chain, `{{define "t%d"}}{{template "t%d" .}}{{template "t%d" .}}{{end}}`, i, i+1, i+1)
+	}
+	chain.WriteString(`{{define "t40"}}x{{end}}{{template "t0" .}}`)
+	for _, tc := range []struct {
+		name  string
+		text  string
+		valid bool
+	}{
+		{"default", DefaultTemplate, true},
+		{"range over a field", "{{range .Item.Categories}}{{.}} {{end}}", true},
+		{"range from the root", "{{with .Item}}{{range $i, $e := $.Item.Enclosures}}{{$e.URL}}{{end}}{{end}}", true},
+		{"conditions", "{{if .Item.Summary}}{{.Item.Summary}}{{else}}{{with .Item.Content}}{{.}}{{end}}{{end}}", true},
+		{"define chain", chain.String(), false},
+		{"block", `{{block "b" .}}{{.Item.Title}}{{end}}`, false},
+		{"template in a branch", `{{if .Item.Title}}{{template "item" .}}{{end}}`, false},
+		{"range over a number", "{{range 1000000000}}{{end}}", false},
+		{"range over a variable", "{{$n := 1000000000}}{{range $n}}{{end}}", false},
+		{"range over dot", "{{with 1000000000}}{{range .}}{{end}}{{end}}", false},
+		{"nested range over a number", "{{range .Item.Categories}}{{range 10}}{{end}}{{end}}", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseTemplate(tc.text)
+			if tc.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestRenderTemplateOutputLimit(t *testing.T) {
+	data := TemplateData{Item: TemplateItem{Title: "Title"}}
+	for i := 0; i < 100; i++ {
+		data.Item.Categories = append(data.Item.Categories, fmt.Sprint("category", i))
+	}
+
+	message, err := renderTemplate("{{range .Item.Categories}}{{.}} {{end}}", time.UTC, data)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(message, "category0 category1 "))
+
+	nested := "{{range .Item.Categories}}{{range $.Item.Categories}}{{range $.Item.Categories}}{{.}}{{end}}{{end}}{{end}}"
+	_, err = renderTemplate(nested, time.UTC, data)
+	assert.ErrorIs(t, err, errTemplateTooLong)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mattermost/mattermost/server/public/model"
+)
+
+// ThreadModes are the ways items can be threaded. The empty mode posts them
+// at the top level.
+var ThreadModes = []string{"off", "feed", "daily"}
+
+const ThreadKeyPrefix = "thread_"
+
+// ThreadRoot is the post the items of a feed are replies to. Day is the
+// date of the root post in daily mode.
+type ThreadRoot struct {
+	PostID string
+	Day    string
+}
+
+func threadKey(feedID string) string {
+	return ThreadKeyPrefix + feedID
+}
+
+// formatThread describes how the items of a feed are threaded.
+func formatThread(feed *Feed) string {
+	switch feed.Thread {
+	case "feed":
+		return "one thread"
+	case "daily":
+		return "a thread per day"
+	}
+	return "off"
+}
+
+// getThreadRoot returns the ID of the post the next items of the feed are
+// replies to, or an empty string when the feed is not threaded. The root
+// post is created when there is none yet, when a new day started in daily
+// mode, or when someone deleted it.
+func (p *Plugin) getThreadRoot(feed *Feed) string {
+	if feed.Thread == "" {
+		return ""
+	}
+	root := &ThreadRoot{}
+	err := p.client.KV.Get(threadKey(feed.ID), root)
+	if err != nil {
+		p.client.Log.Error("Error loading thread: " + err.Error())
+	}
+	day := ""
+	if feed.Thread == "daily" {
+		location, err := time.LoadLocation(p.getChannelTimezone(feed.ChannelID))
+		if err != nil {
+			location = time.UTC
+		}
+		day = time.Now().In(location).Format(time.DateOnly)
+	}
+	if root.PostID != "" && root.Day == day {
+		post, err := p.client.Post.GetPost(root.PostID)
+		if err == nil && post.DeleteAt == 0 {
+			return root.PostID
+		}
+	}
+	title := feed.Title
+	if title == "" {
+		title = feed.URL
+	}
+	message := fmt.Sprintf("**%s**\n\n%s", escapeMarkdown(title), feed.URL)
+	if day != "" {
+		message = fmt.Sprintf("**%s** — %s", escapeMarkdown(title), day)
+	}
+	post := &model.Post{ChannelId: feed.ChannelID, Message: message}
+	p.applyOverrides(feed, post)
+	post, err = p.CreateBotPost(post)
+	if err != nil {
+		p.client.Log.Error("Error posting message: " + err.Error())
+		return ""
+	}
+	_, err = p.client.KV.Set(threadKey(feed.ID), &ThreadRoot{PostID: post.Id, Day: day})
+	if err != nil {
+		p.client.Log.Error("Error saving thread: " + err.Error())
+	}
+	return post.Id
+}
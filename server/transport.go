@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var ErrAddressNotAllowed = errors.New("address is not allowed, see AllowedUntrustedInternalConnections")
+
+// reservedPrefixes are the ranges not covered by the netip predicates used
+// in isReservedIP.
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"),
+}
+
+// isReservedIP reports whether ip is loopback, private, link-local or
+// otherwise not a public unicast address.
+func isReservedIP(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() ||
+		ip.IsMulticast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return true
+	}
+	for _, prefix := range reservedPrefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// untrustedFilter implements ServiceSettings.AllowedUntrustedInternalConnections
+// the way Mattermost core does: a space or comma separated list of host
+// names, IP addresses and CIDR ranges that may be reached even though they
+// are internal.
+type untrustedFilter struct {
+	hosts    []string
+	prefixes []netip.Prefix
+}
+
+func newUntrustedFilter(allowed string) *untrustedFilter {
+	filter := &untrustedFilter{}
+	for _, entry := range strings.FieldsFunc(allowed, func(r rune) bool { return r == ' ' || r == ',' }) {
+		if prefix, err := netip.ParsePrefix(entry); err == nil {
+			filter.prefixes = append(filter.prefixes, prefix.Masked())
+			continue
+		}
+		if ip, err := netip.ParseAddr(entry); err == nil {
+			filter.prefixes = append(filter.prefixes, netip.PrefixFrom(ip, ip.BitLen()))
+			continue
+		}
+		filter.hosts = append(filter.hosts, strings.ToLower(entry))
+	}
+	return filter
+}
+
+func (f *untrustedFilter) allowHost(host string) bool {
+	host = strings.ToLower(host)
+	for _, allowed := range f.hosts {
+		if host == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *untrustedFilter) allowIP(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !isReservedIP(ip) {
+		return true
+	}
+	for _, prefix := range f.prefixes {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// dialContext checks the address actually connected to, after DNS
+// resolution, so a host name can't be rebound to an internal address between
+// a check and the connection.
+func (f *untrustedFilter) dialContext(dialer *net.Dialer) func(ctx context.Context, network, address string) (net.Conn, error) {
+	checked := *dialer
+	checked.Control = func(network, address string, _ syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		ip, err := netip.ParseAddr(host)
+		if err != nil || !f.allowIP(ip) {
+			return ErrAddressNotAllowed
+		}
+		return nil
+	}
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		if f.allowHost(host) {
+			return dialer.DialContext(ctx, network, address)
+		}
+		return checked.DialContext(ctx, network, address)
+	}
+}
+
+// newHTTPClient returns the client used to fetch feeds. It refuses to
+// connect to internal addresses unless the server allows them in
+// ServiceSettings.AllowedUntrustedInternalConnections.
+func (p *Plugin) newHTTPClient(timeout time.Duration) *http.Client {
+	allowed := ""
+	if config := p.API.GetConfig(); config != nil && config.ServiceSettings.AllowedUntrustedInternalConnections != nil {
+		allowed = *config.ServiceSettings.AllowedUntrustedInternalConnections
+	}
+	filter := newUntrustedFilter(allowed)
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           filter.dialContext(dialer),
+			MaxIdleConns:          100,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		},
+	}
+}
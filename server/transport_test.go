@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsReservedIP(t *testing.T) {
+	for _, tc := range []struct {
+		ip       string
+		reserved bool
+	}{
+		{"127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"0.0.0.0", true},
+		{"100.64.0.1", true},
+		{"198.18.0.1", true},
+		{"224.0.0.1", true},
+		{"255.255.255.255", true},
+		{"::1", true},
+		{"fc00::1", true},
+		{"fe80::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"64:ff9b::a00:1", true},
+		{"93.184.216.34", false},
+		{"8.8.8.8", false},
+		{"2606:4700::1111", false},
+		{"::ffff:8.8.8.8", false},
+	} {
+		t.Run(tc.ip, func(t *testing.T) {
+			assert.Equal(t, tc.reserved, isReservedIP(netip.MustParseAddr(tc.ip)))
+		})
+	}
+}
+
+func TestUntrustedFilter(t *testing.T) {
+	filter := newUntrustedFilter("10.0.0.0/8, 192.168.1.5 Intranet.example.com,fd00::/8")
+	for _, tc := range []struct {
+		ip      string
+		allowed bool
+	}{
+		{"10.20.30.40", true},
+		{"192.168.1.5", true},
+		{"::ffff:192.168.1.5", true},
+		{"192.168.1.6", false},
+		{"fd12::1", true},
+		{"127.0.0.1", false},
+		{"8.8.8.8", true},
+	} {
+		t.Run(tc.ip, func(t *testing.T) {
+			assert.Equal(t, tc.allowed, filter.allowIP(netip.MustParseAddr(tc.ip)))
+		})
+	}
+	for _, tc := range []struct {
+		host    string
+		allowed bool
+	}{
+		{"intranet.example.com", true},
+		{"INTRANET.example.com", true},
+		{"other.example.com", false},
+		{"example.com", false},
+	} {
+		t.Run(tc.host, func(t *testing.T) {
+			assert.Equal(t, tc.allowed, filter.allowHost(tc.host))
+		})
+	}
+	assert.False(t, newUntrustedFilter("").allowIP(netip.MustParseAddr("10.0.0.1")))
+}
+
+func TestDialContext(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	_, port, err := net.SplitHostPort(listener.Addr().String())
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name    string
+		allowed string
+		host    string
+		refused bool
+	}{
+		{"internal address", "", "127.0.0.1", true},
+		{"internal host name", "", "localhost", true},
+		{"allowed range", "127.0.0.0/8", "127.0.0.1", false},
+		{"allowed address", "127.0.0.1", "127.0.0.1", false},
+		{"allowed host name", "localhost", "localhost", false},
+		{"other host name allowed", "intranet.example.com", "localhost", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dial := newUntrustedFilter(tc.allowed).dialContext(&net.Dialer{Timeout: time.Second})
+			conn, err := dial(context.Background(), "tcp4", net.JoinHostPort(tc.host, port))
+			if !tc.refused {
+				require.NoError(t, err)
+				conn.Close()
+				return
+			}
+			require.ErrorIs(t, err, ErrAddressNotAllowed)
+			message := describeFetchError(err, time.Second)
+			assert.NotContains(t, message, "127.0.0.1")
+			assert.NotContains(t, message, port)
+		})
+	}
+}
@@ -1,15 +1,97 @@
 package main
 
 import (
+	"sync"
+	"time"
+
 	"github.com/mattermost/mattermost/server/public/plugin"
 	"github.com/mattermost/mattermost/server/public/pluginapi"
 	"github.com/mattermost/mattermost/server/public/pluginapi/cluster"
 )
 
 type Feed struct {
+	ID string
+	// Number identifies the feed among those of its channel in commands. It
+	// is never reused, so it doesn't change when other feeds are deleted.
+	Number    int
+	CreatorID string
+	CreatedAt int64
 	URL       string
 	Updated   int64
 	ChannelID string
+	// Seen holds the identities of recently fetched items. It is nil until
+	// the feed has been fetched once.
+	Seen []string
+	// ETag and LastModified are the validators of the last response, sent
+	// back to make conditional requests.
+	ETag         string
+	LastModified string
+	// Interval is the number of minutes between two fetches. Zero uses the
+	// server default.
+	Interval int
+	// Adaptive makes the feed follow the polling hints of its publisher.
+	Adaptive bool
+	// NextFetch is the Unix time the feed is due to be fetched again.
+	NextFetch int64
+	// PublisherInterval (in seconds), SkipHours and SkipDays are the polling
+	// hints found in the last fetched body.
+	PublisherInterval int64
+	SkipHours         []int
+	SkipDays          []int
+	// LastSuccessAt is when the feed was last fetched successfully, and
+	// LastItemAt when it last had new items to post.
+	LastSuccessAt int64
+	LastItemAt    int64
+	// Failures is the number of consecutive failed fetches.
+	Failures    int
+	LastError   string
+	LastErrorAt int64
+	// Paused feeds are not fetched. PausedReason tells why.
+	Paused       bool
+	PausedReason string
+	// SnoozeUntil is when a snoozed feed is fetched again.
+	SnoozeUntil int64
+	// Template is the text/template items are posted with. Empty uses the
+	// default of the channel.
+	Template string
+	// Filters decide which new items are posted.
+	Filters []FilterRule
+	// Title is the title of the feed as last fetched.
+	Title string
+	// Delivery is one of DeliveryModes, empty for immediate. Digests are
+	// posted at DigestTime (HH:MM) in DigestTimezone, on DigestWeekday for
+	// weekly ones.
+	Delivery       string
+	DigestTime     string
+	DigestWeekday  int
+	DigestTimezone string
+	// NextDigest is the Unix time the next digest is due, zero until the
+	// digest job has scheduled it.
+	NextDigest int64
+	// Thread is one of ThreadModes, empty to post items at the top level.
+	Thread string
+	// Format is one of Formats, empty for plain. Color is the #RRGGBB
+	// color of cards.
+	Format string
+	Color  string
+	// ImageURL and SiteURL are the image and site link of the feed as last
+	// fetched.
+	ImageURL string
+	SiteURL  string
+	// Override posts items under Username and IconURL instead of the name
+	// and icon of the bot. They default to the title and image of the feed.
+	Override bool
+	Username string
+	IconURL  string
+}
+
+// ChannelSettings are the defaults of the feeds of a channel.
+type ChannelSettings struct {
+	Template string
+	// Timezone is the IANA name of the zone dates are formatted in.
+	Timezone string
+	// LastFeedNumber is the Number of the last feed added to the channel.
+	LastFeedNumber int
 }
 
 type Plugin struct {
@@ -17,4 +99,18 @@ type Plugin struct {
 	client        *pluginapi.Client
 	botID         string
 	backgroundJob *cluster.Job
+	digestJob     *cluster.Job
+	jobLock       sync.Mutex
+
+	// scheduleLock guards scheduleRebuiltAt, when the jobs last rebuilt the
+	// schedule on this node.
+	scheduleLock      sync.Mutex
+	scheduleRebuiltAt time.Time
+
+	// configurationLock synchronizes access to the configuration.
+	configurationLock sync.RWMutex
+
+	// configuration is the active plugin configuration. Consult getConfiguration and
+	// setConfiguration for usage.
+	configuration *configuration
 }